@@ -0,0 +1,79 @@
+package promotion
+
+import (
+	"fmt"
+	"sort"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// maxBestCandidates bounds the stackable promos tried in combination, the ones saving the most on their own are kept
+const maxBestCandidates = 8
+
+// RejectedPromo represent a promo which is not part of the best combination and why
+type RejectedPromo struct {
+	PromoID uuid.UUID `json:"promoId"`
+	Code    string    `json:"code"`
+	Reason  string    `json:"reason"`
+}
+
+// BestPromoResponse represent entity of the Best Promo response
+type BestPromoResponse struct {
+	Codes    []string            `json:"codes"`
+	Result   *ApplyPromoResponse `json:"result"`
+	Rejected []*RejectedPromo    `json:"rejected"`
+}
+
+// candidate represent an eligible promo and what it saves on its own
+type candidate struct {
+	promo  *Promotion
+	saving Decimal
+}
+
+// isCandidate reports whether the promo may be picked without the guest giving its code
+func (p *Promotion) isCandidate() bool {
+	return p.Public || p.AutoApply
+}
+
+// stackCombinations returns every combination of the candidates which may be stacked, single promos included
+func stackCombinations(candidates []*candidate) [][]*Promotion {
+	combos := [][]*Promotion{}
+	stackable := []*candidate{}
+	for _, c := range candidates {
+		combos = append(combos, []*Promotion{c.promo})
+		if c.promo.Stackable {
+			stackable = append(stackable, c)
+		}
+	}
+
+	sort.SliceStable(stackable, func(i, j int) bool {
+		return stackable[i].saving > stackable[j].saving
+	})
+	if len(stackable) > maxBestCandidates {
+		stackable = stackable[:maxBestCandidates]
+	}
+
+	for mask := 1; mask < 1<<uint(len(stackable)); mask++ {
+		combo := []*Promotion{}
+		for i, c := range stackable {
+			if mask&(1<<uint(i)) != 0 {
+				combo = append(combo, c.promo)
+			}
+		}
+
+		if len(combo) > 1 && validateStack(combo) == nil {
+			combos = append(combos, combo)
+		}
+	}
+
+	return combos
+}
+
+// rejectionReason explains why an eligible promo is not part of the best combination
+func rejectionReason(promo *Promotion, best []*Promotion, saving Decimal) string {
+	if err := validateStack(append(append([]*Promotion{}, best...), promo)); err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("Saving %v is lower than the selected promos", saving)
+}
@@ -0,0 +1,267 @@
+package promotion
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+var ErrCalendarNotFound = errors.New("Calendar Not Found")
+var ErrCalendarInUse = errors.New("Calendar is used by a promo")
+
+// maxEventDays bounds how many dates a single imported event may black out
+const maxEventDays = 366
+
+// BlackoutCalendar represent a named list of dates promos referencing the calendar are not available on,
+// e.g. public holidays or peak events
+type BlackoutCalendar struct {
+	ID    uuid.UUID       `db:"id" json:"id"`
+	Name  string          `db:"name" json:"name"`
+	Dates []*CalendarDate `json:"dates"`
+}
+
+// CalendarDate represent a single blackout date of a calendar, Date is in utils.DateLayout
+type CalendarDate struct {
+	Date string `db:"date" json:"date"`
+	Name string `db:"name" json:"name"`
+}
+
+// clone returns a copy of the calendar which shares no pointers with c
+func (c *BlackoutCalendar) clone() *BlackoutCalendar {
+	res := *c
+	res.Dates = make([]*CalendarDate, len(c.Dates))
+	for i, d := range c.Dates {
+		date := *d
+		res.Dates[i] = &date
+	}
+	return &res
+}
+
+// blackout returns the calendar date t falls on, nil when t is not blacked out
+func (c *BlackoutCalendar) blackout(t time.Time) *CalendarDate {
+	date := t.Format(utils.DateLayout)
+	for _, d := range c.Dates {
+		if d.Date == date {
+			return d
+		}
+	}
+	return nil
+}
+
+// CalendarRequest represent entity of the Blackout Calendar Request
+type CalendarRequest struct {
+	Name  string          `json:"name"`
+	Dates []*CalendarDate `json:"dates"`
+}
+
+func (req *CalendarRequest) Validate() error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("Calendar Name is required")
+	}
+
+	for _, d := range req.Dates {
+		if _, err := utils.ParseDate(d.Date); err != nil {
+			return fmt.Errorf("Calendar Date '%s' is invalid", d.Date)
+		}
+	}
+
+	return nil
+}
+
+// ToCalendar returns the calendar of the request, the dates are sorted and a date given twice keeps its first name
+func (req *CalendarRequest) ToCalendar(id uuid.UUID) *BlackoutCalendar {
+	calendar := &BlackoutCalendar{ID: id, Name: strings.TrimSpace(req.Name), Dates: []*CalendarDate{}}
+	seen := map[string]bool{}
+	for _, d := range req.Dates {
+		if seen[d.Date] {
+			continue
+		}
+		seen[d.Date] = true
+		calendar.Dates = append(calendar.Dates, &CalendarDate{Date: d.Date, Name: d.Name})
+	}
+
+	sort.SliceStable(calendar.Dates, func(i, j int) bool {
+		return calendar.Dates[i].Date < calendar.Dates[j].Date
+	})
+	return calendar
+}
+
+// ParseICalendar reads the events of an iCalendar (.ics) file into a calendar request, every day an event covers
+// becomes a blackout date named after the event summary. The calendar name comes from X-WR-CALNAME when present.
+// The days are the days in the timezone of X-WR-TIMEZONE, UTC when it is not given, a time of an event is read in
+// its TZID, or UTC with a Z suffix, and converted to it. Recurring events are not expanded, only their first
+// occurrence is read
+func ParseICalendar(r io.Reader) (*CalendarRequest, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// long lines are folded into continuation lines starting with a space or a tab
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	req := &CalendarRequest{Dates: []*CalendarDate{}}
+	timezone := ""
+	events := []map[string]string{}
+	var event map[string]string
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		name, value := strings.ToUpper(line[:i]), line[i+1:]
+		params := ""
+		if j := strings.IndexByte(name, ';'); j >= 0 {
+			name, params = name[:j], line[j+1:i]
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = map[string]string{}
+		case name == "END" && strings.EqualFold(value, "VEVENT") && event != nil:
+			events = append(events, event)
+			event = nil
+		case event != nil:
+			event[name] = value
+			if tzid := icalParam(params, "TZID"); tzid != "" {
+				event[name+";TZID"] = tzid
+			}
+		case name == "X-WR-CALNAME":
+			req.Name = unescapeICalText(value)
+		case name == "X-WR-TIMEZONE":
+			timezone = value
+		}
+	}
+
+	loc, err := utils.LoadTimezone(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Calendar timezone is invalid: %v", err)
+	}
+
+	for _, event := range events {
+		dates, err := eventDates(event, loc)
+		if err != nil {
+			return nil, err
+		}
+		req.Dates = append(req.Dates, dates...)
+	}
+
+	if len(req.Dates) == 0 {
+		return nil, errors.New("Calendar file has no events")
+	}
+	return req, nil
+}
+
+// icalParam returns the value of the named parameter of a property, such as TZID of DTSTART;TZID=Asia/Jakarta
+func icalParam(params, name string) string {
+	for _, param := range strings.Split(params, ";") {
+		if i := strings.IndexByte(param, '='); i >= 0 && strings.EqualFold(param[:i], name) {
+			return strings.Trim(param[i+1:], `"`)
+		}
+	}
+	return ""
+}
+
+// eventDates returns every date in loc an event covers, the end of an event is exclusive. An event without an end
+// covers the date it starts on
+func eventDates(event map[string]string, loc *time.Location) ([]*CalendarDate, error) {
+	summary := unescapeICalText(event["SUMMARY"])
+	start, err := parseICalDate(event["DTSTART"], event["DTSTART;TZID"], loc)
+	if err != nil {
+		return nil, fmt.Errorf("Event '%s' has an invalid start: %v", summary, err)
+	}
+
+	last := start
+	if v, ok := event["DTEND"]; ok {
+		end, err := parseICalDate(v, event["DTEND;TZID"], loc)
+		if err != nil {
+			return nil, fmt.Errorf("Event '%s' has an invalid end: %v", summary, err)
+		}
+		if end.After(start) {
+			last = end.Add(-time.Nanosecond)
+		}
+	}
+
+	dates := []*CalendarDate{}
+	end := dateOf(last)
+	for d := dateOf(start); !d.After(end); d = d.AddDate(0, 0, 1) {
+		if len(dates) == maxEventDays {
+			return nil, fmt.Errorf("Event '%s' is longer than %d days", summary, maxEventDays)
+		}
+		dates = append(dates, &CalendarDate{Date: d.Format(utils.DateLayout), Name: summary})
+	}
+	return dates, nil
+}
+
+// dateOf returns the midnight UTC of the wall clock date of t, so dates of any timezone are stepped by whole days
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// parseICalDate parse a DATE (20201225) or DATE-TIME value into a time in loc. A DATE is the start of the day in
+// loc, a DATE-TIME is UTC with a Z suffix (20201225T100000Z), in tzid when it is given and in loc otherwise
+func parseICalDate(v, tzid string, loc *time.Location) (time.Time, error) {
+	if len(v) == 8 {
+		t, err := time.ParseInLocation("20060102", v, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date '%s'", v)
+		}
+		return t, nil
+	}
+
+	in := loc
+	switch {
+	case strings.HasSuffix(v, "Z"):
+		in, v = time.UTC, strings.TrimSuffix(v, "Z")
+	case tzid != "":
+		var err error
+		if in, err = utils.LoadTimezone(tzid); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	t, err := time.ParseInLocation("20060102T150405", v, in)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s'", v)
+	}
+	return t.In(loc), nil
+}
+
+func unescapeICalText(v string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(v)
+}
+
+// calendarRule checks neither the check-in nor any night of the stay hits a blackout date of the promo calendar
+func (p *Promotion) calendarRule(checkin time.Time, night null.Int) error {
+	if p.calendar == nil {
+		return nil
+	}
+
+	for _, t := range stayNights(checkin, night) {
+		if d := p.calendar.blackout(t); d != nil {
+			message := fmt.Sprintf("Promo is not available on %s (%s), blackout date of calendar %s", d.Date, d.Name, p.calendar.Name)
+			return newFailure(FailureBlackoutDate, message, map[string]interface{}{
+				"date":     d.Date,
+				"name":     d.Name,
+				"calendar": p.calendar.Name,
+			})
+		}
+	}
+
+	return nil
+}
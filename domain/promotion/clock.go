@@ -0,0 +1,31 @@
+package promotion
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidBookingTime = errors.New("Booking Time is invalid")
+
+// Clock represent the source of the current time of the service
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to Clock
+type ClockFunc func() time.Time
+
+// Now returns the time of the function
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// FixedClock returns a clock which always tells t
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time {
+		return t
+	})
+}
+
+// systemClock tells the wall clock time
+var systemClock = ClockFunc(time.Now)
@@ -0,0 +1,151 @@
+package promotion
+
+import "fmt"
+
+const (
+	RuleAnd = "and"
+	RuleOr  = "or"
+	RuleNot = "not"
+)
+
+func init() {
+	RegisterRule(RuleAnd, func() Rule { return &AndRule{} })
+	RegisterRule(RuleOr, func() Rule { return &OrRule{} })
+	RegisterRule(RuleNot, func() Rule { return &NotRule{} })
+}
+
+// RuleTrace represent how a rule was evaluated, Rules holds the trace of every rule of a condition
+// and Params the rule itself when it is not a condition
+type RuleTrace struct {
+	Type     string       `json:"type"`
+	Params   Rule         `json:"params,omitempty"`
+	Passed   bool         `json:"passed"`
+	Failures RuleFailures `json:"failures,omitempty"`
+	Rules    []*RuleTrace `json:"rules,omitempty"`
+}
+
+// conditionRule represent a rule made of other rules, it traces the rules it is made of
+type conditionRule interface {
+	Rule
+	trace(ctx *RuleContext) *RuleTrace
+}
+
+// traceRule checks rule against ctx and returns how it was evaluated
+func traceRule(rule Rule, ctx *RuleContext) *RuleTrace {
+	if c, ok := rule.(conditionRule); ok {
+		return c.trace(ctx)
+	}
+
+	failures := RuleFailures{}.add(rule.Check(ctx))
+	return &RuleTrace{Type: rule.Type(), Params: rule, Passed: len(failures) == 0, Failures: failures}
+}
+
+// traceRules traces every rule of a condition, none is skipped so the trace shows each branch
+func traceRules(rules RuleList, ctx *RuleContext) []*RuleTrace {
+	traces := make([]*RuleTrace, len(rules))
+	for i, rule := range rules {
+		traces[i] = traceRule(rule, ctx)
+	}
+	return traces
+}
+
+func validateCondition(ruleType string, rules RuleList) error {
+	if len(rules) == 0 {
+		return fmt.Errorf("Condition '%s' needs a rule", ruleType)
+	}
+	return rules.validate()
+}
+
+// AndRule represent a condition which passes when all of its rules pass
+type AndRule struct {
+	Rules RuleList `json:"rules"`
+}
+
+func (r *AndRule) Type() string {
+	return RuleAnd
+}
+
+func (r *AndRule) Check(ctx *RuleContext) error {
+	return r.trace(ctx).Failures.err()
+}
+
+func (r *AndRule) Validate() error {
+	return validateCondition(RuleAnd, r.Rules)
+}
+
+func (r *AndRule) trace(ctx *RuleContext) *RuleTrace {
+	res := &RuleTrace{Type: RuleAnd, Rules: traceRules(r.Rules, ctx)}
+	for _, t := range res.Rules {
+		res.Failures = append(res.Failures, t.Failures...)
+	}
+	res.Passed = len(res.Failures) == 0
+	return res
+}
+
+// OrRule represent a condition which passes when one of its rules pass
+type OrRule struct {
+	Rules RuleList `json:"rules"`
+}
+
+func (r *OrRule) Type() string {
+	return RuleOr
+}
+
+func (r *OrRule) Check(ctx *RuleContext) error {
+	return r.trace(ctx).Failures.err()
+}
+
+func (r *OrRule) Validate() error {
+	return validateCondition(RuleOr, r.Rules)
+}
+
+func (r *OrRule) trace(ctx *RuleContext) *RuleTrace {
+	res := &RuleTrace{Type: RuleOr, Rules: traceRules(r.Rules, ctx)}
+	options := [][]*RuleFailure{}
+	for _, t := range res.Rules {
+		if t.Passed {
+			res.Passed = true
+			return res
+		}
+		options = append(options, t.Failures)
+	}
+
+	res.Failures = RuleFailures{newFailure(FailureCondition, "None of the conditions is met", map[string]interface{}{
+		"options": options,
+	})}
+	return res
+}
+
+// NotRule represent a condition which passes when its rules do not all pass
+type NotRule struct {
+	Rules RuleList `json:"rules"`
+}
+
+func (r *NotRule) Type() string {
+	return RuleNot
+}
+
+func (r *NotRule) Check(ctx *RuleContext) error {
+	return r.trace(ctx).Failures.err()
+}
+
+func (r *NotRule) Validate() error {
+	return validateCondition(RuleNot, r.Rules)
+}
+
+func (r *NotRule) trace(ctx *RuleContext) *RuleTrace {
+	inner := (&AndRule{Rules: r.Rules}).trace(ctx)
+	res := &RuleTrace{Type: RuleNot, Passed: !inner.Passed, Rules: inner.Rules}
+	if res.Passed {
+		return res
+	}
+
+	types := make([]string, len(r.Rules))
+	for i, rule := range r.Rules {
+		types[i] = rule.Type()
+	}
+	res.Failures = RuleFailures{newFailure(FailureCondition, "Excluded condition is met", map[string]interface{}{
+		"not": types,
+	})}
+	return res
+}
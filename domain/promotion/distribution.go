@@ -0,0 +1,235 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	DistributionEven            = "even"
+	DistributionFrontLoaded     = "front_loaded"
+	DistributionWeekdayWeighted = "weekday_weighted"
+	DistributionCarryOver       = "carry_over"
+)
+
+var ErrInvalidDateRange = errors.New("Date range is invalid")
+
+// DistributionStrategy represent how the quota of a promo which is not allocated yet is split over the days
+// left. The service allocates the quota by the weights, so no strategy can allocate more than the quota
+type DistributionStrategy interface {
+	// Weights returns the share of every day, days[0] is today in the promo timezone
+	Weights(days []time.Time) []int64
+	// Carry tells whether the daily quota left unused is added to the next day instead of being dropped
+	Carry() bool
+}
+
+var distributionStrategies = map[string]DistributionStrategy{}
+
+// RegisterDistributionStrategy makes a distribution strategy available to promos by name, it panics when the
+// name is registered twice
+func RegisterDistributionStrategy(name string, strategy DistributionStrategy) {
+	if _, ok := distributionStrategies[name]; ok {
+		panic(fmt.Sprintf("distribution strategy '%s' is already registered", name))
+	}
+	distributionStrategies[name] = strategy
+}
+
+func init() {
+	RegisterDistributionStrategy(DistributionEven, evenStrategy{})
+	RegisterDistributionStrategy(DistributionFrontLoaded, frontLoadedStrategy{})
+	RegisterDistributionStrategy(DistributionWeekdayWeighted, weekdayWeightedStrategy{})
+	RegisterDistributionStrategy(DistributionCarryOver, carryOverStrategy{})
+}
+
+// lookupDistributionStrategy returns the strategy registered by name, even when name is empty
+func lookupDistributionStrategy(name string) (DistributionStrategy, error) {
+	if name == "" {
+		name = DistributionEven
+	}
+
+	strategy, ok := distributionStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("Distribution Strategy '%s' is unknown", name)
+	}
+	return strategy, nil
+}
+
+// evenStrategy gives every day the same share
+type evenStrategy struct{}
+
+func (evenStrategy) Weights(days []time.Time) []int64 {
+	weights := make([]int64, len(days))
+	for i := range weights {
+		weights[i] = 1
+	}
+	return weights
+}
+
+func (evenStrategy) Carry() bool {
+	return false
+}
+
+// frontLoadedStrategy gives the first days the most, the share falls by the same step every day
+type frontLoadedStrategy struct{}
+
+func (frontLoadedStrategy) Weights(days []time.Time) []int64 {
+	weights := make([]int64, len(days))
+	for i := range weights {
+		weights[i] = int64(len(days) - i)
+	}
+	return weights
+}
+
+func (frontLoadedStrategy) Carry() bool {
+	return false
+}
+
+// weekdayWeightedStrategy gives a weekend day twice the share of a weekday
+type weekdayWeightedStrategy struct{}
+
+var weekendDays, _ = utils.ParseWeekdays("weekend")
+
+func (weekdayWeightedStrategy) Weights(days []time.Time) []int64 {
+	weights := make([]int64, len(days))
+	for i, day := range days {
+		weights[i] = 1
+		if weekendDays.Contains(day.Weekday()) {
+			weights[i] = 2
+		}
+	}
+	return weights
+}
+
+func (weekdayWeightedStrategy) Carry() bool {
+	return false
+}
+
+// carryOverStrategy splits the quota evenly and adds what was left of the daily quota to the next day
+type carryOverStrategy struct {
+	evenStrategy
+}
+
+func (carryOverStrategy) Carry() bool {
+	return true
+}
+
+// allocateQuota splits quota in proportion to the weights, the units left by rounding down go to the largest
+// remainders and then to the earliest days, so the allocations add up to quota exactly
+func allocateQuota(quota int64, weights []int64) []int64 {
+	res := make([]int64, len(weights))
+	total := int64(0)
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	if quota <= 0 || total == 0 {
+		return res
+	}
+
+	remainders := make([]int64, len(weights))
+	left := quota
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		res[i] = quota * w / total
+		remainders[i] = quota * w % total
+		left -= res[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for k := 0; left > 0; k = (k + 1) % len(order) {
+		if weights[order[k]] > 0 {
+			res[order[k]]++
+			left--
+		}
+	}
+	return res
+}
+
+// distributionDays returns the days left of the promo from t in the promo timezone, a promo without an end
+// date has a single day left
+func (p *Promotion) distributionDays(t time.Time) []time.Time {
+	dayRange := int64(1)
+	from := t
+	if p.StartDate.Valid && p.EndDate.Valid {
+		duration := p.EndDate.Time.Sub(p.StartDate.Time)
+		from = p.StartDate.Time
+		if t.After(p.StartDate.Time) && t.Before(p.EndDate.Time) {
+			duration = p.EndDate.Time.Sub(t)
+			from = t
+		}
+		dayRange = int64(math.Ceil(duration.Hours() / 24))
+	}
+
+	if dayRange < 1 {
+		dayRange = 1
+	}
+
+	from = from.In(p.location())
+	days := make([]time.Time, dayRange)
+	for i := range days {
+		days[i] = from.AddDate(0, 0, i)
+	}
+	return days
+}
+
+// GetDistributionLedger represent the distributed days of a promo from and to a date of the promo timezone, an
+// empty from or to leaves the range open. The quota left on a day expires unless it is carried to the next
+// distributed day, the last day expires once the promo has ended
+func (s *Service) GetDistributionLedger(id uuid.UUID, from, to string) ([]*PromoDistribution, error) {
+	for _, date := range []string{from, to} {
+		if date == "" {
+			continue
+		}
+		if _, err := utils.ParseDate(date); err != nil {
+			return nil, ErrInvalidDateRange
+		}
+	}
+	if from != "" && to != "" && from > to {
+		return nil, ErrInvalidDateRange
+	}
+
+	p, err := s.repo.GetPromotionByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the day after to is read as well, it tells how much of the last day was carried
+	days, err := s.repo.GetDistributionLedger(id, from, "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get distribution ledger: %v", err)
+	}
+
+	ended := p.EndDate.Valid && !s.clock.Now().Before(p.EndDate.Time)
+	res := []*PromoDistribution{}
+	for i, day := range days {
+		if to != "" && day.Date > to {
+			break
+		}
+
+		if i+1 < len(days) {
+			day.Expired = day.Balance - days[i+1].Carried
+		} else if ended {
+			day.Expired = day.Balance
+		}
+		if day.Expired < 0 {
+			day.Expired = 0
+		}
+		res = append(res, day)
+	}
+	return res, nil
+}
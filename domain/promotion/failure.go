@@ -0,0 +1,114 @@
+package promotion
+
+import (
+	"fmt"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// FailureCode represent a machine readable reason a promo did not apply
+type FailureCode string
+
+const (
+	FailurePromoUnavailable FailureCode = "PROMO_UNAVAILABLE"
+	FailureQuotaExhausted   FailureCode = "QUOTA_EXHAUSTED"
+	FailureSlotExhausted    FailureCode = "SLOT_EXHAUSTED"
+	FailureDateRange        FailureCode = "DATE_RANGE"
+	FailureMinNight         FailureCode = "MIN_NIGHT"
+	FailureMinRoom          FailureCode = "MIN_ROOM"
+	FailureCheckinDay       FailureCode = "CHECKIN_DAY"
+	FailureBookingDay       FailureCode = "BOOKING_DAY"
+	FailureBookingHour      FailureCode = "BOOKING_HOUR"
+	FailureBlackoutDate     FailureCode = "BLACKOUT_DATE"
+	FailureStayDates        FailureCode = "STAY_DATES"
+	FailureMinSpend         FailureCode = "MIN_SPEND"
+	FailureGuestRequired    FailureCode = "GUEST_REQUIRED"
+	FailureGuestLimit       FailureCode = "GUEST_LIMIT"
+	FailureGuestDailyLimit  FailureCode = "GUEST_DAILY_LIMIT"
+	FailureFirstBooking     FailureCode = "FIRST_BOOKING"
+	FailureCondition        FailureCode = "CONDITION"
+	FailureNoEligibleRoom   FailureCode = "NO_ELIGIBLE_ROOM"
+	FailureInvalidPromo     FailureCode = "INVALID_PROMO"
+)
+
+// RuleFailure represent a promo rule which failed, Params holds what the rule asks for such as the required
+// minimum, so a client can describe the failure in its own words
+type RuleFailure struct {
+	PromoID   uuid.UUID              `json:"promoId"`
+	PromoCode string                 `json:"promoCode"`
+	Code      FailureCode            `json:"code"`
+	Message   string                 `json:"message"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+}
+
+func newFailure(code FailureCode, message string, params map[string]interface{}) *RuleFailure {
+	return &RuleFailure{Code: code, Message: message, Params: params}
+}
+
+func (f *RuleFailure) Error() string {
+	return f.Message
+}
+
+// RuleFailures represent every promo rule which failed
+type RuleFailures []*RuleFailure
+
+func (f RuleFailures) Error() string {
+	messages := make([]string, len(f))
+	for i, failure := range f {
+		messages[i] = failure.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add appends the failures of a rule error, an error which is not a rule failure means the promo itself is invalid
+func (f RuleFailures) add(err error) RuleFailures {
+	switch e := err.(type) {
+	case nil:
+		return f
+	case *RuleFailure:
+		return append(f, e)
+	case RuleFailures:
+		return append(f, e...)
+	}
+
+	if err == ErrPromoNotApplied {
+		return append(f, newFailure(FailureNoEligibleRoom, err.Error(), nil))
+	}
+	return append(f, newFailure(FailureInvalidPromo, err.Error(), nil))
+}
+
+// err returns the failures as an error, nil when no rule failed
+func (f RuleFailures) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return f
+}
+
+// promoFailures returns the failures of err stamped with the promo they belong to
+func promoFailures(promo *Promotion, err error) []*RuleFailure {
+	failures := RuleFailures{}.add(err)
+	for _, f := range failures {
+		f.PromoID = promo.ID
+		f.PromoCode = promo.Code
+	}
+	return failures
+}
+
+// failureMessage describes why promos did not apply, naming the promo of every failure when several promos
+// are stacked
+func failureMessage(failures []*RuleFailure, stacked bool) string {
+	if len(failures) == 0 {
+		return ""
+	}
+
+	messages := make([]string, len(failures))
+	for i, f := range failures {
+		messages[i] = f.Message
+		if stacked {
+			messages[i] = fmt.Sprintf("%s: %s", f.PromoCode, f.Message)
+		}
+	}
+	return fmt.Sprintf("Promo not applied: %s", strings.Join(messages, "; "))
+}
@@ -0,0 +1,126 @@
+package promotion
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+// guestUse represent a promo redeemed or held by a booking of the guest
+type guestUse struct {
+	promoID    uuid.UUID
+	bookingRef string
+	at         time.Time
+}
+
+// guestHistory represent the promos a guest has used, a held reservation counts until it is released
+type guestHistory struct {
+	guest string
+	uses  []guestUse
+}
+
+// guest returns the guest of the request without surrounding spaces
+func (req *ApplyPromoRequest) guest() string {
+	return strings.TrimSpace(req.Guest)
+}
+
+// hasGuestLimits reports whether the promo limits how often a guest uses it
+func (p *Promotion) hasGuestLimits() bool {
+	return p.GuestLimit.Valid || p.DailyLimit.Valid || p.FirstBooking
+}
+
+// loadGuest loads the promo use of the guest into every promo limited per guest, nothing is loaded without a
+// guest and the promos fail with GUEST_REQUIRED
+func (s *Service) loadGuest(promos []*Promotion, guest string, now time.Time) error {
+	limited := false
+	for _, promo := range promos {
+		limited = limited || promo.hasGuestLimits()
+	}
+	if !limited || guest == "" {
+		return nil
+	}
+
+	redemptions, err := s.repo.GetRedemptionsByGuest(guest)
+	if err != nil {
+		return fmt.Errorf("Failed to get guest redemptions: %v", err)
+	}
+
+	holds, err := s.repo.GetHoldsByGuest(guest)
+	if err != nil {
+		return fmt.Errorf("Failed to get guest reservations: %v", err)
+	}
+
+	history := &guestHistory{guest: guest}
+	for _, rd := range redemptions {
+		history.uses = append(history.uses, guestUse{promoID: rd.PromoID, bookingRef: rd.BookingRef, at: rd.CreatedAt})
+	}
+	for _, h := range holds {
+		if h.Status == HoldStatusHeld && !h.isExpired(now) {
+			history.uses = append(history.uses, guestUse{promoID: h.PromoID, bookingRef: h.BookingRef, at: h.CreatedAt})
+		}
+	}
+
+	for _, promo := range promos {
+		if promo.hasGuestLimits() {
+			promo.guest = history
+		}
+	}
+	return nil
+}
+
+// guestRule checks the guest has not used the promo up to its limits, the day is the day of the booking time in
+// the promo timezone. A first booking promo only applies to a guest without any booking with a promo
+func (p *Promotion) guestRule(bookingTime time.Time) error {
+	if !p.hasGuestLimits() {
+		return nil
+	}
+
+	if p.guest == nil {
+		return newFailure(FailureGuestRequired, "Promo is limited per guest, the guest is required", nil)
+	}
+
+	loc := p.location()
+	date := bookingTime.In(loc).Format(utils.DateLayout)
+	used, usedToday := int64(0), int64(0)
+	bookings := map[string]bool{}
+	for _, use := range p.guest.uses {
+		bookings[use.bookingRef] = true
+		if use.promoID != p.ID {
+			continue
+		}
+		used++
+		if use.at.In(loc).Format(utils.DateLayout) == date {
+			usedToday++
+		}
+	}
+
+	failures := RuleFailures{}
+	if p.GuestLimit.Valid && used >= p.GuestLimit.Int64 {
+		failures = failures.add(newFailure(FailureGuestLimit, fmt.Sprintf("Guest Limit rule is failed, use at most %d times", p.GuestLimit.Int64),
+			map[string]interface{}{
+				"limit": p.GuestLimit.Int64,
+				"used":  used,
+			}))
+	}
+
+	if p.DailyLimit.Valid && usedToday >= p.DailyLimit.Int64 {
+		failures = failures.add(newFailure(FailureGuestDailyLimit, fmt.Sprintf("Guest Daily Limit rule is failed, use at most %d times a day", p.DailyLimit.Int64),
+			map[string]interface{}{
+				"limit": p.DailyLimit.Int64,
+				"used":  usedToday,
+				"date":  date,
+			}))
+	}
+
+	if p.FirstBooking && len(bookings) > 0 {
+		failures = failures.add(newFailure(FailureFirstBooking, "First Booking rule is failed, the guest has booked before",
+			map[string]interface{}{
+				"bookings": len(bookings),
+			}))
+	}
+
+	return failures.err()
+}
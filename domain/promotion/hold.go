@@ -0,0 +1,81 @@
+package promotion
+
+import (
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultHoldTTL is how long a reservation holds the promo quota when no TTL is requested
+const DefaultHoldTTL = 15 * time.Minute
+
+// MaxHoldTTL is the longest a reservation is allowed to hold the promo quota
+const MaxHoldTTL = 24 * time.Hour
+
+var ErrHoldNotFound = errors.New("Promo Reservation Not Found")
+var ErrHoldExpired = errors.New("Promo Reservation has expired")
+var ErrHoldNotHeld = errors.New("Promo Reservation is no longer held")
+var ErrBookingAlreadyReserved = errors.New("Booking has already reserved a promo")
+var ErrInvalidHoldTTL = errors.New("Reservation TTL is out of range")
+
+// HoldStatus represent state of a promo reservation
+type HoldStatus string
+
+const (
+	HoldStatusHeld      HoldStatus = "held"
+	HoldStatusConfirmed HoldStatus = "confirmed"
+	HoldStatusCancelled HoldStatus = "cancelled"
+	HoldStatusExpired   HoldStatus = "expired"
+)
+
+// Hold represent a unit of promo quota held for a booking until it is confirmed, cancelled or expired
+type Hold struct {
+	ID            uuid.UUID  `db:"id" json:"id"`
+	PromoID       uuid.UUID  `db:"promo_id" json:"promoId"`
+	Code          string     `db:"code" json:"code"`
+	BookingRef    string     `db:"booking_ref" json:"bookingRef"`
+	Guest         string     `db:"guest" json:"guestId"`
+	Status        HoldStatus `db:"status" json:"status"`
+	Currency      string     `db:"currency" json:"currency"`
+	OriginalPrice Decimal    `db:"original_price" json:"originalPrice"`
+	PromoPrice    Decimal    `db:"promo_price" json:"promoPrice"`
+	FinalPrice    Decimal    `db:"final_price" json:"finalPrice"`
+	CreatedAt     time.Time  `db:"created_at" json:"createdAt"`
+	ExpiresAt     time.Time  `db:"expires_at" json:"expiresAt"`
+}
+
+func (h *Hold) isExpired(t time.Time) bool {
+	return !t.Before(h.ExpiresAt)
+}
+
+// ReservePromoRequest represent entity of the Reserve Promo params
+type ReservePromoRequest struct {
+	RedeemPromoRequest
+	TTL int64 `json:"ttl"`
+}
+
+// ttl returns the requested hold duration, TTL is given in seconds
+func (req *ReservePromoRequest) ttl() (time.Duration, error) {
+	if req.TTL == 0 {
+		return DefaultHoldTTL, nil
+	}
+
+	ttl := time.Duration(req.TTL) * time.Second
+	if ttl < 0 || ttl > MaxHoldTTL {
+		return 0, ErrInvalidHoldTTL
+	}
+
+	return ttl, nil
+}
+
+// ReservePromoResponse represent entity of the Reserve Promo response
+type ReservePromoResponse struct {
+	Holds  []*Hold             `json:"holds"`
+	Result *ApplyPromoResponse `json:"result"`
+}
+
+// ConfirmReservationResponse represent entity of the Confirm Reservation response
+type ConfirmReservationResponse struct {
+	Redemptions []*Redemption `json:"redemptions"`
+}
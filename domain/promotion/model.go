@@ -2,7 +2,7 @@ package promotion
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"time"
 
 	"github.com/chandrafortuna/simple-promotion-api/utils"
@@ -10,264 +10,555 @@ import (
 	"gopkg.in/guregu/null.v3"
 )
 
+var ErrQuotaBelowUsed = errors.New("Quota can not be lower than the quota already used")
+
 // Promotion represent entity of the promo
 type Promotion struct {
-	ID               uuid.UUID          `db:"id" json:"id"`
-	Title            string             `db:"title" json:"title"`
-	Code             string             `db:"code" json:"code"`
-	StartDate        null.Time          `db:"start_date" json:"startDate"`
-	EndDate          null.Time          `db:"end_date" json:"endDate"`
-	Percentage       null.Int           `db:"percentage" json:"percentage"`
-	Amount           null.Float         `db:"amount" json:"amount"`
-	Qty              int64              `db:"qty" json:"qty"`
-	Redeem           int64              `db:"reedem" json:"redeem"`
-	Balance          int64              `db:"balance" json:"balance"`
-	Status           int64              `db:"status" json:"status"`
-	MinNight         null.Int           `db:"min_night" json:"minNight"`
-	MinRoom          null.Int           `db:"min_room" json:"minRoom"`
-	CheckinDays      null.String        `db:"checkin_day" json:"checkinDays"`
-	BookingDays      null.String        `db:"booking_day" json:"bookingDays"`
-	BookingHourStart null.Int           `db:"booking_hour_start" json:"bookingHourStart"`
-	BookingHourEnd   null.Int           `db:"booking_hour_end" json:"bookingHourEnd"`
-	Distribution     *PromoDistribution `db:"distribution" json:"distribution"`
+	ID             uuid.UUID          `db:"id" json:"id"`
+	Title          string             `db:"title" json:"title"`
+	Code           string             `db:"code" json:"code"`
+	StartDate      null.Time          `db:"start_date" json:"startDate"`
+	EndDate        null.Time          `db:"end_date" json:"endDate"`
+	Percentage     null.Int           `db:"percentage" json:"percentage"`
+	Amount         NullDecimal        `db:"amount" json:"amount"`
+	Currency       string             `db:"currency" json:"currency"`
+	MaxDiscount    NullDecimal        `db:"max_discount" json:"maxDiscount"`
+	MinPrice       NullDecimal        `db:"min_price" json:"minPrice"`
+	MinSpend       NullDecimal        `db:"min_spend" json:"minSpend"`
+	Scope          PromoScope         `db:"scope" json:"scope"`
+	Timezone       string             `db:"timezone" json:"timezone"`
+	Strategy       string             `db:"distribution_strategy" json:"distributionStrategy"`
+	Qty            int64              `db:"qty" json:"qty"`
+	Allocated      int64              `db:"allocated" json:"allocated"`
+	Redeem         int64              `db:"reedem" json:"redeem"`
+	Balance        int64              `db:"balance" json:"balance"`
+	Status         PromoStatus        `db:"status" json:"status"`
+	StayStart      null.Time          `db:"stay_start" json:"stayStart"`
+	StayEnd        null.Time          `db:"stay_end" json:"stayEnd"`
+	StayDays       null.String        `db:"stay_days" json:"stayDays"`
+	BlackoutDates  DateList           `db:"blackout_dates" json:"blackoutDates"`
+	CalendarID     *uuid.UUID         `db:"calendar_id" json:"calendarId"`
+	Rules          RuleList           `db:"rules" json:"rules"`
+	Stackable      bool               `db:"stackable" json:"stackable"`
+	ExclusiveGroup null.String        `db:"exclusive_group" json:"exclusiveGroup"`
+	Public         bool               `db:"public" json:"public"`
+	AutoApply      bool               `db:"auto_apply" json:"autoApply"`
+	Slots          *SlotConfig        `db:"slots" json:"slots"`
+	GuestLimit     null.Int           `db:"guest_limit" json:"guestLimit"`
+	DailyLimit     null.Int           `db:"guest_daily_limit" json:"guestDailyLimit"`
+	FirstBooking   bool               `db:"first_booking" json:"firstBookingOnly"`
+	Distribution   *PromoDistribution `db:"distribution" json:"distribution"`
+	Version        int64              `db:"version" json:"version"`
+	// calendar is the blackout calendar of CalendarID, the service loads it before the rules are applied
+	calendar *BlackoutCalendar
+	// guest is the promo use of the guest booking, the service loads it before the rules are applied
+	guest *guestHistory
 }
 
-func (p *Promotion) CalculatePromo(price float64) (float64, error) {
-	if p.Percentage.Valid {
-		return p.calculatePromoPercentage(price)
+// clone returns a copy of the promo which shares no pointers with p
+func (p *Promotion) clone() *Promotion {
+	c := *p
+	if p.Distribution != nil {
+		d := *p.Distribution
+		c.Distribution = &d
+	}
+	c.BlackoutDates = p.BlackoutDates.clone()
+	if p.CalendarID != nil {
+		id := *p.CalendarID
+		c.CalendarID = &id
 	}
+	c.Rules = p.Rules.clone()
+	c.Slots = p.Slots.clone()
+	return &c
+}
 
-	if p.Amount.Valid {
-		return p.calculatePromoAmount(price)
+// CalculatePromo returns the price after the promo, the discount is rounded by the rounding rule for the price
+// currency then capped by MaxDiscount and never takes the price below MinPrice or zero. An amount promo, and a
+// promo with limits, only applies to a price in its own currency
+func (p *Promotion) CalculatePromo(price Money, rounding Rounding) (Money, error) {
+	var discount Decimal
+	var err error
+	rounding = rounding.forCurrency(price.Currency)
+	switch {
+	case p.Percentage.Valid:
+		discount, err = p.calculatePromoPercentage(price, rounding)
+	case p.Amount.Valid:
+		discount, err = p.calculatePromoAmount(price, rounding)
+	default:
+		return Money{}, errors.New("Invalid Promotion Percentage/Amount")
+	}
+	if err != nil {
+		return Money{}, err
 	}
 
-	return 0, errors.New("Invalid Promotion Percentage/Amount")
+	discount, err = p.limitDiscount(price, discount)
+	if err != nil {
+		return Money{}, err
+	}
 
+	return Money{Amount: price.Amount - discount, Currency: price.Currency}, nil
 }
 
-func (p *Promotion) calculatePromoPercentage(price float64) (float64, error) {
-	promoPercentage := p.Percentage.Int64
-	discount := (price * float64(promoPercentage) / float64(100))
-	newPrice := price - discount
-	log.Println("promoPercentage:", promoPercentage)
-	log.Println("discount:", discount)
-	log.Println("calculatePromoPercentage:", newPrice)
-	return newPrice, nil
+func (p *Promotion) calculatePromoPercentage(price Money, rounding Rounding) (Decimal, error) {
+	return price.Amount.Percent(p.Percentage.Int64).Round(rounding), nil
 }
 
-func (p *Promotion) calculatePromoAmount(price float64) (float64, error) {
-	promoAmount := p.Amount.Float64
-	newPrice := price - promoAmount
-	log.Println("calculatePromoPercentage:", newPrice)
-	return newPrice, nil
+func (p *Promotion) calculatePromoAmount(price Money, rounding Rounding) (Decimal, error) {
+	if p.currency() != price.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+
+	return p.Amount.Decimal.Round(rounding), nil
 }
 
-func (p *Promotion) hasBalance() bool {
-	log.Println("p.Balance", p.Balance)
-	if p.Distribution == nil {
-		return p.Balance > 0
+// hasLimits reports whether the promo sets any amount limit, the limits are in the promo currency
+func (p *Promotion) hasLimits() bool {
+	return p.MaxDiscount.Valid || p.MinPrice.Valid || p.MinSpend.Valid
+}
+
+// limitDiscount caps the discount by MaxDiscount and keeps the price at or above MinPrice and zero
+func (p *Promotion) limitDiscount(price Money, discount Decimal) (Decimal, error) {
+	if p.hasLimits() && p.currency() != price.Currency {
+		return 0, ErrCurrencyMismatch
 	}
 
-	log.Println("p.Distribution.Balance", p.Distribution.Balance)
-	return p.Distribution.Balance > 0
-}
+	if p.MaxDiscount.Valid && discount > p.MaxDiscount.Decimal {
+		discount = p.MaxDiscount.Decimal
+	}
 
-func (p *Promotion) dateRangeRule(t time.Time) error {
-	if !p.StartDate.Valid || !p.EndDate.Valid {
-		return nil
+	floor := Decimal(0)
+	if p.MinPrice.Valid && p.MinPrice.Decimal > floor {
+		floor = p.MinPrice.Decimal
 	}
 
-	log.Println("BookingTime:", t)
-	if t.After(p.StartDate.Time) && t.Before(p.EndDate.Time) {
-		return nil
+	if price.Amount-discount < floor {
+		discount = price.Amount - floor
 	}
 
-	return errors.New("Promo is not started or has been ended")
+	if discount < 0 {
+		discount = 0
+	}
+
+	return discount, nil
 }
 
-func (p *Promotion) checkinRule(t time.Time) error {
-	if !p.CheckinDays.Valid {
+// minSpendRule checks the room price reaches MinSpend before the promo applies
+func (p *Promotion) minSpendRule(price Money) error {
+	if !p.MinSpend.Valid {
 		return nil
 	}
 
-	checkinDayRule, err := utils.ParseWeekday(p.CheckinDays.String)
-	if err != nil {
-		return errors.New("Promo Checkin Day is Invalid")
+	if p.currency() != price.Currency {
+		return ErrCurrencyMismatch
 	}
 
-	if checkinDayRule != t.Weekday() {
-		return errors.New("checkin time rule is failed")
+	if price.Amount < p.MinSpend.Decimal {
+		return newFailure(FailureMinSpend, fmt.Sprintf("Min Spend rule is failed, spend at least %s %s", p.MinSpend.Decimal, p.currency()),
+			map[string]interface{}{
+				"required": p.MinSpend.Decimal,
+				"actual":   price.Amount,
+				"currency": p.currency(),
+			})
 	}
 
 	return nil
 }
 
-func (p *Promotion) bookingDayRule(t time.Time) error {
-	if !p.BookingDays.Valid {
-		return nil
+// currency returns the currency of the promo amount
+func (p *Promotion) currency() string {
+	if p.Currency == "" {
+		return DefaultCurrency
 	}
+	return p.Currency
+}
 
-	bookingDayRule, err := utils.ParseWeekday(p.BookingDays.String)
-	if err != nil {
-		return errors.New("Promo Booking Day is Invalid")
+// hasQuota reports whether the promo has overall and daily quota left
+func (p *Promotion) hasQuota() bool {
+	if p.Distribution == nil || p.Balance <= 0 {
+		return p.Balance > 0
 	}
 
-	if bookingDayRule != t.Weekday() {
-		return errors.New("booking time rule is failed")
+	return p.Distribution.Balance > 0
+}
+
+// hasBalance reports whether the promo has quota left for a booking at t, a flash sale needs quota left in the
+// slot of t as well
+func (p *Promotion) hasBalance(t time.Time) bool {
+	return p.hasQuota() && (p.Slots == nil || p.slotBalance(t) > 0)
+}
+
+// consume takes one unit from the overall, the daily and the slot quota at t
+func (p *Promotion) consume(t time.Time) error {
+	if err := p.hold(t); err != nil {
+		return err
 	}
 
+	p.confirm()
 	return nil
 }
 
-func (p *Promotion) bookingHourRule(t time.Time) error {
-	if !p.BookingHourStart.Valid || !p.BookingHourEnd.Valid {
-		return nil
+// hold takes one unit from the overall, the daily and the slot balance at t without redeeming it yet
+func (p *Promotion) hold(t time.Time) error {
+	if !p.hasQuota() {
+		return ErrPromoQuotaExhausted
+	}
+	if !p.hasBalance(t) {
+		return ErrPromoSlotExhausted
 	}
 
-	bookingHour := int64(t.Hour())
-	if bookingHour >= p.BookingHourStart.Int64 && bookingHour <= p.BookingHourEnd.Int64 {
-		return nil
+	p.Balance--
+	if p.Distribution != nil {
+		p.Distribution.Balance--
 	}
+	p.takeSlot(t)
 
-	return errors.New("booking hour rule is failed")
+	return nil
 }
 
-func (p *Promotion) minNightRule(n null.Int) error {
-	if !p.MinNight.Valid {
-		return nil
+// confirm turns a held unit into a redemption
+func (p *Promotion) confirm() {
+	p.Redeem++
+	if p.Distribution != nil {
+		p.Distribution.Redeem++
 	}
+}
 
-	if !n.Valid {
-		return errors.New("This promo apply min night")
+// release returns a unit held at heldAt to the overall and the daily balance, and to its slot while it lasts
+func (p *Promotion) release(heldAt time.Time) {
+	p.Balance++
+	if p.Distribution != nil {
+		p.Distribution.Balance++
 	}
+	p.releaseSlot(heldAt)
+}
 
-	if n.Int64 < p.MinNight.Int64 {
-		return errors.New("Min Night rule is failed")
+// location returns the timezone the promo dates, booking days and booking hours are read in, UTC when it is not set
+func (p *Promotion) location() *time.Location {
+	loc, err := utils.LoadTimezone(p.Timezone)
+	if err != nil {
+		return time.UTC
 	}
+	return loc
+}
 
-	return nil
+// dateRange returns the rule of the promo start and end date
+func (p *Promotion) dateRange() *DateRangeRule {
+	return &DateRangeRule{Start: p.StartDate, End: p.EndDate}
 }
 
-func (p *Promotion) minRoomRule(n null.Int) error {
-	log.Println("p.MinRoom.Valid:", p.MinRoom.Valid)
-	if !p.MinRoom.Valid {
-		return nil
+// availableRule checks the promo is active and has quota left for a booking at t
+func (p *Promotion) availableRule(t time.Time) error {
+	if p.Status == StatusExhausted || !p.hasQuota() {
+		return newFailure(FailureQuotaExhausted, "Promo quota is exhausted", nil)
+	}
+
+	if p.Status != StatusActive {
+		return newFailure(FailurePromoUnavailable, "Promo is not available", map[string]interface{}{
+			"status": p.Status,
+		})
 	}
 
-	if !n.Valid {
-		return errors.New("This promo apply min room")
+	return p.slotRule(t)
+}
+
+// ApplyRule checks the availability, the date range, the blackout calendar and every rule of the promo against
+// a room, it does not stop at the first failure. The error is RuleFailures listing every rule which failed
+func (p *Promotion) ApplyRule(checkinTime time.Time, bookingTime time.Time, night null.Int, room null.Int) error {
+	_, err := p.TraceRule(checkinTime, bookingTime, night, room)
+	return err
+}
+
+// TraceRule checks the promo like ApplyRule and returns how every rule of the promo was evaluated
+func (p *Promotion) TraceRule(checkinTime time.Time, bookingTime time.Time, night null.Int, room null.Int) ([]*RuleTrace, error) {
+	ctx := &RuleContext{
+		Checkin:     checkinTime,
+		BookingTime: bookingTime.In(p.location()),
+		Night:       night,
+		Room:        room,
 	}
 
-	log.Println("p.MinRoom.Int64:", p.MinRoom.Int64)
-	if n.Int64 < p.MinRoom.Int64 {
-		return errors.New("Min Room rule is failed")
+	failures := RuleFailures{}
+	failures = failures.add(p.availableRule(ctx.BookingTime))
+	failures = failures.add(p.dateRange().Check(ctx))
+	failures = failures.add(p.calendarRule(checkinTime, night))
+	failures = failures.add(p.guestRule(ctx.BookingTime))
+	traces := traceRules(p.Rules, ctx)
+	for _, t := range traces {
+		failures = append(failures, t.Failures...)
 	}
 
-	return nil
+	return traces, failures.err()
 }
 
-func (p *Promotion) ApplyRule(checkinTime time.Time, bookingTime time.Time, night null.Int, room null.Int) error {
+// PromoDistribution represent the quota of a promo on a day of its timezone, the promo holds the day distributed
+// last and the days before are kept as its ledger. Qty is the daily quota, the quota Allocated that day plus
+// the quota Carried from the day before. Expired is the quota left unused which was not carried to the next
+// day, it is only known in the ledger. SlotBalance is the quota left in the flash sale slot of SlotStart
+type PromoDistribution struct {
+	PromoID       uuid.UUID `db:"promo_id" json:"promoId"`
+	Date          string    `db:"date" json:"date"`
+	Qty           int64     `db:"qty" json:"qty"`
+	Redeem        int64     `db:"reedem" json:"redeem"`
+	Balance       int64     `db:"balance" json:"balance"`
+	Carried       int64     `db:"carried" json:"carried"`
+	Allocated     int64     `db:"allocated" json:"allocated"`
+	Expired       int64     `db:"-" json:"expired"`
+	DistributedAt null.Time `db:"distributed_at" json:"distributedAt"`
+	NextRunAt     null.Time `db:"next_run_at" json:"nextRunAt"`
+	SlotStart     null.Time `db:"slot_start" json:"slotStart"`
+	SlotBalance   int64     `db:"slot_balance" json:"slotBalance"`
+}
+
+// PromoRequest represent entity of the Promotion Request
+type PromoRequest struct {
+	Title          string      `json:"title"`
+	Code           string      `json:"code"`
+	StartDate      null.String `json:"startDate"`
+	EndDate        null.String `json:"endDate"`
+	Percentage     null.Int    `json:"percentage"`
+	Amount         NullDecimal `json:"amount"`
+	Currency       string      `json:"currency"`
+	MaxDiscount    NullDecimal `json:"maxDiscount"`
+	MinPrice       NullDecimal `json:"minPrice"`
+	MinSpend       NullDecimal `json:"minSpend"`
+	Scope          PromoScope  `json:"scope"`
+	Timezone       string      `json:"timezone"`
+	Quota          int64       `json:"quota"`
+	Strategy       string      `json:"distributionStrategy"`
+	MinNight       null.Int    `json:"minNight"`
+	MinRoom        null.Int    `json:"minRoom"`
+	CheckinDays    null.String `json:"checkinDays"`
+	BookingDays    null.String `json:"bookingDays"`
+	StayStart      null.String `json:"stayStart"`
+	StayEnd        null.String `json:"stayEnd"`
+	StayDays       null.String `json:"stayDays"`
+	BlackoutDates  DateList    `json:"blackoutDates"`
+	CalendarID     *uuid.UUID  `json:"calendarId"`
+	BookingWindows WindowList  `json:"bookingWindows"`
+	Slots          *SlotConfig `json:"slots"`
+	GuestLimit     null.Int    `json:"guestLimit"`
+	DailyLimit     null.Int    `json:"guestDailyLimit"`
+	FirstBooking   bool        `json:"firstBookingOnly"`
+	Rules          RuleList    `json:"rules"`
+	Stackable      bool        `json:"stackable"`
+	ExclusiveGroup null.String `json:"exclusiveGroup"`
+	Public         bool        `json:"public"`
+	AutoApply      bool        `json:"autoApply"`
+	Draft          bool        `json:"draft"`
+	// Version is the version of the promo the edit is made from, the edit fails when the promo has changed since
+	Version null.Int `json:"version"`
+}
 
-	if !p.hasBalance() {
-		return errors.New("Promo is not available")
+// NewPromoRequest represent the request which describes an existing promo, it is the base of a partial edit
+func NewPromoRequest(p *Promotion) PromoRequest {
+	req := PromoRequest{
+		Title:          p.Title,
+		Code:           p.Code,
+		Percentage:     p.Percentage,
+		Amount:         p.Amount,
+		Currency:       p.Currency,
+		MaxDiscount:    p.MaxDiscount,
+		MinPrice:       p.MinPrice,
+		MinSpend:       p.MinSpend,
+		Scope:          p.Scope,
+		Timezone:       p.Timezone,
+		Quota:          p.Qty,
+		Strategy:       p.Strategy,
+		StayDays:       p.StayDays,
+		BlackoutDates:  p.BlackoutDates.clone(),
+		CalendarID:     p.CalendarID,
+		Rules:          p.Rules.clone(),
+		Slots:          p.Slots.clone(),
+		GuestLimit:     p.GuestLimit,
+		DailyLimit:     p.DailyLimit,
+		FirstBooking:   p.FirstBooking,
+		Stackable:      p.Stackable,
+		ExclusiveGroup: p.ExclusiveGroup,
+		Public:         p.Public,
+		AutoApply:      p.AutoApply,
+		Version:        null.IntFrom(p.Version),
 	}
 
-	if err := p.dateRangeRule(bookingTime); err != nil {
-		return err
+	if p.CalendarID != nil {
+		id := *p.CalendarID
+		req.CalendarID = &id
 	}
 
-	if err := p.minNightRule(night); err != nil {
-		return err
+	if p.StartDate.Valid && p.EndDate.Valid {
+		loc := p.location()
+		req.StartDate = null.StringFrom(p.StartDate.Time.In(loc).Format(utils.TimeLayout))
+		req.EndDate = null.StringFrom(p.EndDate.Time.In(loc).Format(utils.TimeLayout))
+	}
+
+	if p.StayStart.Valid {
+		req.StayStart = null.StringFrom(p.StayStart.Time.Format(utils.DateLayout))
 	}
 
-	if err := p.minRoomRule(room); err != nil {
+	if p.StayEnd.Valid {
+		req.StayEnd = null.StringFrom(p.StayEnd.Time.Format(utils.DateLayout))
+	}
+
+	return req
+}
+
+func (req *PromoRequest) ToPromo(id uuid.UUID) (*Promotion, error) {
+	promo := &Promotion{
+		ID:     id,
+		Status: StatusActive,
+	}
+	if req.Draft {
+		promo.Status = StatusDraft
+	}
+
+	if err := req.ApplyTo(promo); err != nil {
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+// ApplyTo overwrites the promo definition with the request, the quota already used by redemptions and holds is kept.
+// The request is validated first, so a promo is created and updated with the same checks
+func (req *PromoRequest) ApplyTo(promo *Promotion) error {
+	if err := req.Validate(); err != nil {
 		return err
 	}
 
-	if err := p.checkinRule(checkinTime); err != nil {
+	dates, err := req.dates()
+	if err != nil {
 		return err
 	}
 
-	if err := p.bookingDayRule(bookingTime); err != nil {
+	used := promo.Qty - promo.Balance
+	if req.Quota < used {
+		return ErrQuotaBelowUsed
+	}
+
+	currency, err := normalizeCurrency(req.Currency)
+	if err != nil {
 		return err
 	}
 
-	if err := p.bookingHourRule(bookingTime); err != nil {
+	scope, err := ParsePromoScope(string(req.Scope))
+	if err != nil {
 		return err
 	}
 
+	promo.Title = req.Title
+	promo.Code = req.Code
+	promo.StartDate = dates.StartDate
+	promo.EndDate = dates.EndDate
+	promo.Percentage = req.Percentage
+	promo.Amount = req.Amount
+	promo.Currency = currency
+	promo.MaxDiscount = req.MaxDiscount
+	promo.MinPrice = req.MinPrice
+	promo.MinSpend = req.MinSpend
+	promo.Scope = scope
+	promo.Timezone = req.Timezone
+	promo.Qty = req.Quota
+	promo.Strategy = req.Strategy
+	promo.Balance = req.Quota - used
+	promo.StayStart = dates.StayStart
+	promo.StayEnd = dates.StayEnd
+	promo.StayDays = req.StayDays
+	promo.BlackoutDates = req.BlackoutDates
+	promo.CalendarID = req.CalendarID
+	promo.Rules = req.rules()
+	promo.Slots = req.Slots.clone()
+	promo.GuestLimit = req.GuestLimit
+	promo.DailyLimit = req.DailyLimit
+	promo.FirstBooking = req.FirstBooking
+	promo.Stackable = req.Stackable
+	promo.ExclusiveGroup = req.ExclusiveGroup
+	promo.Public = req.Public
+	promo.AutoApply = req.AutoApply
+
 	return nil
 }
 
-// PromoDistribution represent entity of the promo
-type PromoDistribution struct {
-	PromoID uuid.UUID `db:"promo_id" json:"promoId"`
-	Qty     int64     `db:"qty" json:"qty"`
-	Redeem  int64     `db:"reedem" json:"redeem"`
-	Balance int64     `db:"balance" json:"balance"`
+// rules returns the rules of the request, the minNight, minRoom, checkinDays, bookingDays and bookingWindows
+// shorthands replace the rule of their type
+func (req *PromoRequest) rules() RuleList {
+	rules := req.Rules.clone()
+	if req.MinNight.Valid {
+		rules = rules.set(&MinNightRule{Min: req.MinNight.Int64})
+	}
+	if req.MinRoom.Valid {
+		rules = rules.set(&MinRoomRule{Min: req.MinRoom.Int64})
+	}
+	if req.CheckinDays.Valid {
+		rules = rules.set(&CheckinDayRule{Days: req.CheckinDays.String})
+	}
+	if req.BookingDays.Valid {
+		rules = rules.set(&BookingDayRule{Days: req.BookingDays.String})
+	}
+	if len(req.BookingWindows) > 0 {
+		rules = rules.set(&BookingWindowRule{Windows: req.BookingWindows.clone()})
+	}
+	return rules
 }
 
-// PromoRequest represent entity of the Promotion Request
-type PromoRequest struct {
-	Title            string      `json:"title"`
-	Code             string      `json:"code"`
-	StartDate        null.String `json:"startDate"`
-	EndDate          null.String `json:"endDate"`
-	Percentage       null.Int    `json:"percentage"`
-	Amount           null.Float  `json:"amount"`
-	Quota            int64       `json:"quota"`
-	MinNight         null.Int    `json:"minNight"`
-	MinRoom          null.Int    `json:"minRoom"`
-	CheckinDays      null.String `json:"checkinDays"`
-	BookingDays      null.String `json:"bookingDays"`
-	BookingHourStart null.Int    `json:"bookingHourStart"`
-	BookingHourEnd   null.Int    `json:"bookingHourEnd"`
+// promoDates represent the validity period of a promo request in its timezone and its stay period
+type promoDates struct {
+	StartDate null.Time
+	EndDate   null.Time
+	StayStart null.Time
+	StayEnd   null.Time
 }
 
-func (req *PromoRequest) ToPromo(id uuid.UUID) (*Promotion, error) {
-	var startDate, endDate null.Time
-	if req.StartDate.Valid && req.EndDate.Valid {
-		_startDate, err := utils.ParseTimeFromString(utils.NullStringToString(req.StartDate))
+// dates parses the validity and stay periods of the request, the end date lasts until the end of its day
+func (req *PromoRequest) dates() (*promoDates, error) {
+	loc, err := utils.LoadTimezone(req.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Timezone is invalid: %v", err)
+	}
+
+	dates := &promoDates{}
+	if req.StartDate.Valid || req.EndDate.Valid {
+		if req.StartDate.String == "" || req.EndDate.String == "" {
+			return nil, errors.New("Start Date and End Date range required")
+		}
+
+		startDate, err := utils.ParseTimeIn(req.StartDate.String, loc)
 		if err != nil {
-			return nil, err
+			return nil, errors.New("Start Date is invalid")
 		}
-		startDate = null.TimeFrom(_startDate)
 
-		_endDate, err := utils.ParseTimeFromString(utils.NullStringToString(req.EndDate))
+		endDate, err := utils.ParseTimeIn(req.EndDate.String, loc)
 		if err != nil {
-			return nil, err
+			return nil, errors.New("End Date is invalid")
 		}
-		endOfDay := time.Date(_endDate.Year(), _endDate.Month(), _endDate.Day(), 23, 59, 59, 0, _endDate.Location())
-		endDate = null.TimeFrom(endOfDay)
 
-		if _startDate.After(_endDate) {
-			err = errors.New("End Date must greather than Start Date")
-			return nil, err
+		if startDate.After(endDate) {
+			return nil, errors.New("End Date must greather than Start Date")
 		}
+
+		dates.StartDate = null.TimeFrom(startDate)
+		dates.EndDate = null.TimeFrom(time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 23, 59, 59, 0, loc))
 	}
 
-	promo := &Promotion{
-		ID:               id,
-		Title:            req.Title,
-		Code:             req.Code,
-		StartDate:        startDate,
-		EndDate:          endDate,
-		Percentage:       req.Percentage,
-		Amount:           req.Amount,
-		Qty:              req.Quota,
-		Redeem:           0,
-		Balance:          req.Quota,
-		Status:           1,
-		MinNight:         req.MinNight,
-		MinRoom:          req.MinRoom,
-		CheckinDays:      req.CheckinDays,
-		BookingDays:      req.BookingDays,
-		BookingHourStart: req.BookingHourStart,
-		BookingHourEnd:   req.BookingHourEnd,
-		Distribution:     nil,
+	if req.StayStart.Valid {
+		stayStart, err := utils.ParseDate(req.StayStart.String)
+		if err != nil {
+			return nil, errors.New("Stay Start is invalid")
+		}
+		dates.StayStart = null.TimeFrom(stayStart)
 	}
 
-	return promo, nil
+	if req.StayEnd.Valid {
+		stayEnd, err := utils.ParseDate(req.StayEnd.String)
+		if err != nil {
+			return nil, errors.New("Stay End is invalid")
+		}
+		dates.StayEnd = null.TimeFrom(stayEnd)
+	}
+
+	if dates.StayStart.Valid && dates.StayEnd.Valid && dates.StayStart.Time.After(dates.StayEnd.Time) {
+		return nil, errors.New("Stay End must greather than Stay Start")
+	}
+
+	return dates, nil
 }
 
 func (promoReq *PromoRequest) Validate() error {
@@ -279,12 +570,68 @@ func (promoReq *PromoRequest) Validate() error {
 		return errors.New("You have to fill only one either Percentage or Amount")
 	}
 
-	if promoReq.StartDate.Valid || promoReq.EndDate.Valid {
-		if promoReq.StartDate.String == "" || promoReq.EndDate.String == "" {
-			return errors.New("Start Date and End Date range required")
+	if promoReq.Percentage.Valid && (promoReq.Percentage.Int64 < 0 || promoReq.Percentage.Int64 > 100) {
+		return errors.New("Percentage must be between 0 and 100")
+	}
+
+	if promoReq.Amount.Valid && promoReq.Amount.Decimal < 0 {
+		return errors.New("Amount can not be negative")
+	}
+
+	if promoReq.MaxDiscount.Valid && promoReq.MaxDiscount.Decimal < 0 {
+		return errors.New("Max Discount can not be negative")
+	}
+
+	if promoReq.MinPrice.Valid && promoReq.MinPrice.Decimal < 0 {
+		return errors.New("Min Price can not be negative")
+	}
+
+	if promoReq.MinSpend.Valid && promoReq.MinSpend.Decimal < 0 {
+		return errors.New("Min Spend can not be negative")
+	}
+
+	if _, err := promoReq.dates(); err != nil {
+		return err
+	}
+
+	if promoReq.Quota < 0 {
+		return errors.New("Quota can not be negative")
+	}
+
+	if promoReq.StayDays.Valid {
+		if _, err := utils.ParseWeekdays(promoReq.StayDays.String); err != nil {
+			return fmt.Errorf("Stay Days is invalid: %v", err)
 		}
 	}
 
+	if err := promoReq.BlackoutDates.validate(); err != nil {
+		return err
+	}
+
+	if err := promoReq.rules().validate(); err != nil {
+		return err
+	}
+
+	if _, err := normalizeCurrency(promoReq.Currency); err != nil {
+		return err
+	}
+
+	if _, err := ParsePromoScope(string(promoReq.Scope)); err != nil {
+		return err
+	}
+
+	if _, err := lookupDistributionStrategy(promoReq.Strategy); err != nil {
+		return err
+	}
+
+	if err := promoReq.Slots.validate(); err != nil {
+		return err
+	}
+
+	if (promoReq.GuestLimit.Valid && promoReq.GuestLimit.Int64 <= 0) || (promoReq.DailyLimit.Valid && promoReq.DailyLimit.Int64 <= 0) {
+		return errors.New("Guest Limit must be greater than 0")
+	}
+
 	return nil
 }
 
@@ -292,34 +639,95 @@ func (promoReq *PromoRequest) Validate() error {
 type RoomRequest struct {
 	Date  string   `json:"date"`
 	Room  string   `json:"room"`
-	Price float64  `json:"price"`
+	Price Decimal  `json:"price"`
 	Night null.Int `json:"night"`
 	Qty   null.Int `json:"qty"`
 }
 
-// ApplyPromoRequest represent entity of the PromoRequest params
+// validate checks the nights of the room are within MaxNights, every night of the stay is priced
+func (r *RoomRequest) validate() error {
+	if r.Night.Valid && r.Night.Int64 > MaxNights {
+		return ErrInvalidNight
+	}
+	return nil
+}
+
+// ApplyPromoRequest represent entity of the PromoRequest params, Codes stacks several promos.
+// Every room price is in Currency, the booking total is the sum of the room prices
 type ApplyPromoRequest struct {
-	Rooms      []*RoomRequest `json:"rooms"`
-	TotalPrice float64        `json:"totalPrice"`
-	Code       string         `json:"code"`
+	Rooms    []*RoomRequest `json:"rooms"`
+	Currency string         `json:"currency"`
+	Code     string         `json:"code"`
+	Codes    []string       `json:"codes"`
+	// Guest identifies who books, such as a user ID, an email hash or a phone, for the promo limits per guest
+	Guest string `json:"guestId"`
+	// BookingTime overrides when the booking is made, only the preview honors it
+	BookingTime null.String `json:"bookingTime"`
+}
+
+// codes returns every requested promo code, Code first
+func (req *ApplyPromoRequest) codes() []string {
+	codes := []string{}
+	if req.Code != "" {
+		codes = append(codes, req.Code)
+	}
+	return append(codes, req.Codes...)
 }
 
 // RoomResponse represent entity of the Room Response
 type RoomResponse struct {
-	Date       time.Time `json:"date"`
-	Room       string    `json:"room"`
-	Price      float64   `json:"price"`
-	Night      null.Int  `json:"night"`
-	Qty        null.Int  `json:"qty"`
-	PromoPrice float64   `json:"promoPrice"`
-	Saving     float64   `json:"saving"`
-	Message    string    `json:"message"`
+	Date       time.Time         `json:"date"`
+	Room       string            `json:"room"`
+	Price      Decimal           `json:"price"`
+	Night      null.Int          `json:"night"`
+	Qty        null.Int          `json:"qty"`
+	PromoPrice Decimal           `json:"promoPrice"`
+	Saving     Decimal           `json:"saving"`
+	Message    string            `json:"message"`
+	Failures   []*RuleFailure    `json:"failures"`
+	Promos     []*PromoBreakdown `json:"promos"`
+	Nights     []*NightResponse  `json:"nights"`
 }
 
 // ApplyPromoResponse represent entity of the Promo response
 type ApplyPromoResponse struct {
 	Rooms         []*RoomResponse `json:"rooms"`
-	PromoPrice    float64         `json:"promoPrice"`
-	FinalPrice    float64         `json:"finalPrice"`
-	OriginalPrice float64         `json:"originalPrice"`
+	Order         *OrderResponse  `json:"order,omitempty"`
+	Currency      string          `json:"currency"`
+	PromoPrice    Decimal         `json:"promoPrice"`
+	FinalPrice    Decimal         `json:"finalPrice"`
+	OriginalPrice Decimal         `json:"originalPrice"`
+}
+
+// promoSaving sums what the promo saved over every room
+func (pr *ApplyPromoResponse) promoSaving(id uuid.UUID) Decimal {
+	saving := Decimal(0)
+	for _, room := range pr.Rooms {
+		for _, breakdown := range room.Promos {
+			if breakdown.PromoID == id {
+				saving += breakdown.Saving
+			}
+		}
+	}
+	return saving
+}
+
+// promoMessage returns why the promo did not apply, the booking level reason first
+func (pr *ApplyPromoResponse) promoMessage(id uuid.UUID) string {
+	if pr.Order != nil {
+		for _, breakdown := range pr.Order.Promos {
+			if breakdown.PromoID == id && breakdown.Message != "" {
+				return breakdown.Message
+			}
+		}
+	}
+
+	for _, room := range pr.Rooms {
+		for _, breakdown := range room.Promos {
+			if breakdown.PromoID == id && breakdown.Message != "" {
+				return breakdown.Message
+			}
+		}
+	}
+	return ""
 }
@@ -0,0 +1,276 @@
+package promotion
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("Promo amount currency does not match the price currency")
+	ErrInvalidCurrency  = errors.New("Currency must be a 3 letter ISO 4217 code")
+)
+
+// DefaultCurrency is the currency of prices and promo amounts which do not name one
+const DefaultCurrency = "IDR"
+
+// decimalScale is how many units of a Decimal make one, Decimal keeps two fraction digits
+const decimalScale = 100
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Decimal represent a fixed point number with two fraction digits, stored as hundredths so sums never drift
+type Decimal int64
+
+// NewDecimal returns the Decimal of a whole number
+func NewDecimal(v int64) Decimal {
+	return Decimal(v * decimalScale)
+}
+
+// ParseDecimal parse string such as "150000" or "1250.5" into Decimal, more than two fraction digits are rounded half up
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("invalid decimal '%s'", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid decimal '%s'", s)
+	}
+
+	cents := int64(0)
+	for i, c := range fraction {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid decimal '%s'", s)
+		}
+		digit := int64(c - '0')
+		switch {
+		case i == 0:
+			cents += digit * 10
+		case i == 1:
+			cents += digit
+		case i == 2 && digit >= 5:
+			cents++
+		}
+	}
+
+	d := Decimal(units*decimalScale + cents)
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+func (d Decimal) String() string {
+	v := int64(d)
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+
+	cents := v % decimalScale
+	if cents == 0 {
+		return fmt.Sprintf("%s%d", sign, v/decimalScale)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%02d", sign, v/decimalScale, cents), "0")
+}
+
+// Float64 returns the Decimal as float64, for display only
+func (d Decimal) Float64() float64 {
+	return float64(d) / decimalScale
+}
+
+// Percent returns pct percent of the Decimal, rounded half up to the cent
+func (d Decimal) Percent(pct int64) Decimal {
+	return Decimal(divRoundHalfUp(int64(d)*pct, 100))
+}
+
+// Round rounds the Decimal by the rule
+func (d Decimal) Round(rule Rounding) Decimal {
+	if rule == RoundNearest100 {
+		return Decimal(divRoundHalfUp(int64(d), 100*decimalScale) * 100 * decimalScale)
+	}
+	return d
+}
+
+// MarshalJSON writes the Decimal as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads the Decimal from a JSON number or string without going through float64
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*d = 0
+		return nil
+	}
+
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Value implements the driver Valuer interface, the Decimal is stored as exact text
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan implements the Scanner interface
+func (d *Decimal) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*d = 0
+		return nil
+	case int64:
+		*d = NewDecimal(v)
+		return nil
+	case float64:
+		return d.UnmarshalJSON([]byte(strconv.FormatFloat(v, 'f', -1, 64)))
+	case []byte:
+		return d.UnmarshalJSON(v)
+	case string:
+		return d.UnmarshalJSON([]byte(v))
+	}
+
+	return fmt.Errorf("can not scan %T into Decimal", value)
+}
+
+// NullDecimal represent a Decimal which may be null
+type NullDecimal struct {
+	Decimal Decimal
+	Valid   bool
+}
+
+// NullDecimalFrom returns a valid NullDecimal
+func NullDecimalFrom(d Decimal) NullDecimal {
+	return NullDecimal{Decimal: d, Valid: true}
+}
+
+// MarshalJSON writes null when the NullDecimal is not valid
+func (n NullDecimal) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return n.Decimal.MarshalJSON()
+}
+
+// UnmarshalJSON reads null and the empty string as an invalid NullDecimal
+func (n *NullDecimal) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*n = NullDecimal{}
+		return nil
+	}
+
+	if err := n.Decimal.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// Value implements the driver Valuer interface
+func (n NullDecimal) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Decimal.Value()
+}
+
+// Scan implements the Scanner interface
+func (n *NullDecimal) Scan(value interface{}) error {
+	if value == nil {
+		*n = NullDecimal{}
+		return nil
+	}
+
+	if err := n.Decimal.Scan(value); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// Money represent an amount in a currency
+type Money struct {
+	Amount   Decimal `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// Rounding represent how a promo discount is rounded
+type Rounding string
+
+const (
+	// RoundHalfUp rounds the discount half up to the cent
+	RoundHalfUp Rounding = "half_up"
+	// RoundNearest100 rounds an IDR discount half up to the nearest 100, other currencies are rounded half up
+	// to the cent
+	RoundNearest100 Rounding = "nearest_100"
+)
+
+// forCurrency returns the rule a discount in the currency is rounded by, only IDR is rounded to the nearest 100
+func (r Rounding) forCurrency(currency string) Rounding {
+	if r == RoundNearest100 && currency != "" && currency != "IDR" {
+		return RoundHalfUp
+	}
+	return r
+}
+
+// ParseRounding parse string into Rounding, empty string is half up
+func ParseRounding(v string) (Rounding, error) {
+	switch Rounding(v) {
+	case "", RoundHalfUp:
+		return RoundHalfUp, nil
+	case RoundNearest100:
+		return RoundNearest100, nil
+	}
+
+	return "", fmt.Errorf("invalid rounding '%s'", v)
+}
+
+// normalizeCurrency upper cases the currency and falls back to DefaultCurrency
+func normalizeCurrency(currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return DefaultCurrency, nil
+	}
+
+	if !currencyPattern.MatchString(currency) {
+		return "", ErrInvalidCurrency
+	}
+	return currency, nil
+}
+
+// divRoundHalfUp divides rounding half away from zero
+func divRoundHalfUp(n, d int64) int64 {
+	q, r := n/d, n%d
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= d {
+		if n < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
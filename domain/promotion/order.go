@@ -0,0 +1,186 @@
+package promotion
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// PromoScope represent what a promo discounts, a room price or the whole booking
+type PromoScope string
+
+const (
+	ScopeRoom  PromoScope = "room"
+	ScopeOrder PromoScope = "order"
+)
+
+// ParsePromoScope parse string into PromoScope, empty string is room
+func ParsePromoScope(v string) (PromoScope, error) {
+	switch PromoScope(v) {
+	case "", ScopeRoom:
+		return ScopeRoom, nil
+	case ScopeOrder:
+		return ScopeOrder, nil
+	}
+
+	return "", fmt.Errorf("invalid promo scope '%s'", v)
+}
+
+// scope returns what the promo discounts, room when it is not set
+func (p *Promotion) scope() PromoScope {
+	if p.Scope == "" {
+		return ScopeRoom
+	}
+	return p.Scope
+}
+
+// isOrderScoped reports whether the promo discounts the whole booking
+func (p *Promotion) isOrderScoped() bool {
+	return p.scope() == ScopeOrder
+}
+
+// OrderResponse represent the booking level result of the order promos, TotalPrice is the booking total
+// after the room promos. Every room shows its share of an order promo in its promos
+type OrderResponse struct {
+	TotalPrice Decimal           `json:"totalPrice"`
+	PromoPrice Decimal           `json:"promoPrice"`
+	FinalPrice Decimal           `json:"finalPrice"`
+	Message    string            `json:"message"`
+	Failures   []*RuleFailure    `json:"failures"`
+	Promos     []*PromoBreakdown `json:"promos"`
+}
+
+// splitScope separates the room promos from the order promos, keeping their order
+func splitScope(promos []*Promotion) (roomPromos, orderPromos []*Promotion) {
+	for _, promo := range promos {
+		if promo.isOrderScoped() {
+			orderPromos = append(orderPromos, promo)
+		} else {
+			roomPromos = append(roomPromos, promo)
+		}
+	}
+	return roomPromos, orderPromos
+}
+
+// applyOrderPromos discounts the booking total with every order promo after the room promos have been applied.
+// The booking total is the sum of the room prices. A promo only discounts, and checks the min spend of, the rooms
+// its rules accept, the discount is split over them in proportion to their price. Rule failures of a room are
+// added to failures
+func (s *Service) applyOrderPromos(promos []*Promotion, req ApplyPromoRequest, pr *ApplyPromoResponse, checkins []time.Time,
+	failures [][]*RuleFailure, bookingTime time.Time, applied map[uuid.UUID]int, stacked bool) (*OrderResponse, error) {
+	basket := Decimal(0)
+	for _, room := range pr.Rooms {
+		basket += room.PromoPrice
+	}
+
+	order := &OrderResponse{TotalPrice: basket, Promos: []*PromoBreakdown{}}
+	orderFailures := []*RuleFailure{}
+	for _, promo := range promos {
+		breakdown := &PromoBreakdown{PromoID: promo.ID, Code: promo.Code}
+		order.Promos = append(order.Promos, breakdown)
+
+		eligible := []int{}
+		for i, room := range req.Rooms {
+			roomFailures := RuleFailures{}.add(promo.ApplyRule(checkins[i], bookingTime, room.Night, room.Qty))
+			_, err := promo.stayRule(pr.Rooms[i].Nights)
+			roomFailures = roomFailures.add(err)
+			if len(roomFailures) > 0 {
+				failures[i] = append(failures[i], promoFailures(promo, roomFailures)...)
+				continue
+			}
+			eligible = append(eligible, i)
+		}
+
+		weights := make([]Decimal, len(eligible))
+		eligibleTotal := Decimal(0)
+		for k, i := range eligible {
+			weights[k] = pr.Rooms[i].PromoPrice
+			eligibleTotal += weights[k]
+		}
+
+		price := Money{Amount: eligibleTotal, Currency: pr.Currency}
+		err := ErrPromoNotApplied
+		if len(eligible) > 0 {
+			err = promo.minSpendRule(price)
+		}
+		if err == ErrCurrencyMismatch {
+			return nil, err
+		}
+		if err != nil {
+			breakdown.Message = err.Error()
+			orderFailures = append(orderFailures, promoFailures(promo, err)...)
+			continue
+		}
+
+		newPrice, err := promo.CalculatePromo(price, s.rounding)
+		if err == ErrCurrencyMismatch {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Promo calculation failed: %v", err)
+		}
+
+		discount := eligibleTotal - newPrice.Amount
+		for k, share := range allocate(discount, weights) {
+			if share == 0 {
+				// a room the promo saves nothing on does not use the promo
+				continue
+			}
+
+			room := pr.Rooms[eligible[k]]
+			room.PromoPrice -= share
+			spreadSaving(room.Nights, share, nil)
+			room.Promos = append(room.Promos, &PromoBreakdown{PromoID: promo.ID, Code: promo.Code, Saving: share})
+			applied[promo.ID]++
+		}
+
+		breakdown.Saving = discount
+		basket -= discount
+	}
+
+	order.FinalPrice = basket
+	order.PromoPrice = order.TotalPrice - basket
+	order.Failures = orderFailures
+	order.Message = failureMessage(orderFailures, stacked)
+
+	return order, nil
+}
+
+// allocate splits total in proportion to the weights, the cents left by rounding down go to the largest remainders
+// so the shares always add up to total
+func allocate(total Decimal, weights []Decimal) []Decimal {
+	shares := make([]Decimal, len(weights))
+	sum := big.NewInt(0)
+	for _, w := range weights {
+		sum.Add(sum, big.NewInt(int64(w)))
+	}
+	if sum.Sign() == 0 {
+		return shares
+	}
+
+	remainders := make([]*big.Int, len(weights))
+	left := total
+	for i, w := range weights {
+		q, r := new(big.Int).QuoRem(new(big.Int).Mul(big.NewInt(int64(total)), big.NewInt(int64(w))), sum, new(big.Int))
+		shares[i] = Decimal(q.Int64())
+		remainders[i] = r
+		left -= shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]].Cmp(remainders[order[b]]) > 0
+	})
+	for k := 0; left > 0; k++ {
+		shares[order[k%len(order)]]++
+		left--
+	}
+
+	return shares
+}
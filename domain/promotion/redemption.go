@@ -0,0 +1,39 @@
+package promotion
+
+import (
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var ErrPromoNotApplied = errors.New("Promo is not applicable to any room")
+var ErrPromoQuotaExhausted = errors.New("Promo quota is exhausted")
+var ErrBookingRefRequired = errors.New("Booking Reference is required")
+var ErrBookingAlreadyRedeemed = errors.New("Booking has already redeemed a promo")
+
+// Redemption represent a unit of promo quota consumed by a booking, PromoPrice is what the promo saved
+type Redemption struct {
+	ID            uuid.UUID `db:"id" json:"id"`
+	PromoID       uuid.UUID `db:"promo_id" json:"promoId"`
+	Code          string    `db:"code" json:"code"`
+	BookingRef    string    `db:"booking_ref" json:"bookingRef"`
+	Guest         string    `db:"guest" json:"guestId"`
+	Currency      string    `db:"currency" json:"currency"`
+	OriginalPrice Decimal   `db:"original_price" json:"originalPrice"`
+	PromoPrice    Decimal   `db:"promo_price" json:"promoPrice"`
+	FinalPrice    Decimal   `db:"final_price" json:"finalPrice"`
+	CreatedAt     time.Time `db:"created_at" json:"createdAt"`
+}
+
+// RedeemPromoRequest represent entity of the Redeem Promo params
+type RedeemPromoRequest struct {
+	ApplyPromoRequest
+	BookingRef string `json:"bookingRef"`
+}
+
+// RedeemPromoResponse represent entity of the Redeem Promo response
+type RedeemPromoResponse struct {
+	Redemptions []*Redemption       `json:"redemptions"`
+	Result      *ApplyPromoResponse `json:"result"`
+}
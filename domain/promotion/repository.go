@@ -2,7 +2,9 @@ package promotion
 
 import (
 	"errors"
-	"log"
+	"sort"
+	"sync"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 )
@@ -13,23 +15,78 @@ type Repository interface {
 	GetPromotionByID(id uuid.UUID) (*Promotion, error)
 	ExistsByCode(code string) (bool, error)
 	GetAllAvailable() ([]*Promotion, error)
+	GetAllByStatus(statuses ...PromoStatus) ([]*Promotion, error)
 	Save(*Promotion) error
 	Update(*Promotion) error
+	UpdateMany([]*Promotion) error
+	SaveBooking(*BookingChange) error
+	GetDistributionLedger(promoID uuid.UUID, from, to string) ([]*PromoDistribution, error)
+	GetRedemptionsByBookingRef(ref string) ([]*Redemption, error)
+	SaveRedemption(*Redemption) error
+	GetRedemptionsByGuest(guest string) ([]*Redemption, error)
+	GetHoldsByBookingRef(ref string) ([]*Hold, error)
+	GetHoldsByGuest(guest string) ([]*Hold, error)
+	GetGuestVersion(guest string) (int64, error)
+	GetExpiredHolds(t time.Time) ([]*Hold, error)
+	SaveHold(*Hold) error
+	UpdateHold(*Hold) error
+	GetCalendarByID(id uuid.UUID) (*BlackoutCalendar, error)
+	GetAllCalendars() ([]*BlackoutCalendar, error)
+	SaveCalendar(*BlackoutCalendar) error
+	UpdateCalendar(*BlackoutCalendar) error
+	DeleteCalendar(id uuid.UUID) error
+	AcquireLock(name, owner string, now, until time.Time) (bool, error)
+	ReleaseLock(name, owner string) error
+	GetJobRun(job string) (*JobRun, error)
+	SaveJobRun(*JobRun) error
+}
+
+// BookingChange represent the writes of a booking which are made all together or none of them, the promos are
+// updated like UpdateMany, the holds and the redemptions are saved and the settled holds leave the held status
+// like UpdateHold. A booking of Guest bumps the guest version, it conflicts when the guest has booked since
+// GuestVersion was read
+type BookingChange struct {
+	Promos       []*Promotion
+	Holds        []*Hold
+	Settled      []*Hold
+	Redemptions  []*Redemption
+	Guest        string
+	GuestVersion int64
 }
 
 var ErrPromoNotFound = errors.New("Promo Not Found")
 var ErrPromoDistributionNotFound = errors.New("Promo Distribution Not Found")
 
-// TempRepository represent temporary repository of promo
+var ErrPromoVersionConflict = errors.New("Promo has been modified concurrently")
+var ErrDuplicatePromoCode = errors.New("Duplicated Promo code")
+
+// TempRepository represent temporary repository of promo, it is safe for concurrent use
+// and hands out copies so callers never share a promo with the collection
 type TempRepository struct {
-	promoCollection []*Promotion
+	mu                   sync.RWMutex
+	promoCollection      []*Promotion
+	distributionLedger   []PromoDistribution
+	redemptionCollection []*Redemption
+	holdCollection       []*Hold
+	guestVersions        map[string]int64
+	calendarCollection   []*BlackoutCalendar
+	locks                map[string]jobLock
+	jobRuns              map[string]JobRun
+}
+
+// jobLock represent a lock held by owner until it expires
+type jobLock struct {
+	owner string
+	until time.Time
 }
 
 // GetPromotionByCode represent get promotion by code
 func (r *TempRepository) GetPromotionByCode(code string) (*Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	for _, promo := range r.promoCollection {
 		if promo.Code == code {
-			return promo, nil
+			return promo.clone(), nil
 		}
 	}
 	return nil, ErrPromoNotFound
@@ -37,9 +94,11 @@ func (r *TempRepository) GetPromotionByCode(code string) (*Promotion, error) {
 
 // GetPromotionByID represent get promotion by ID
 func (r *TempRepository) GetPromotionByID(id uuid.UUID) (*Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	for _, promo := range r.promoCollection {
 		if promo.ID == id {
-			return promo, nil
+			return promo.clone(), nil
 		}
 	}
 	return nil, ErrPromoNotFound
@@ -47,10 +106,9 @@ func (r *TempRepository) GetPromotionByID(id uuid.UUID) (*Promotion, error) {
 
 // ExistsByCode represent get existance promotion code
 func (r *TempRepository) ExistsByCode(code string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	for _, promo := range r.promoCollection {
-		log.Println("promo.Code", promo.Code)
-		log.Println("code", code)
-		log.Println("promo.Code == code", promo.Code == code)
 		if promo.Code == code {
 			return true, nil
 		}
@@ -58,12 +116,22 @@ func (r *TempRepository) ExistsByCode(code string) (bool, error) {
 	return false, nil
 }
 
-// GetAllAvailable represent get all promotion
+// GetAllAvailable represent get all active promotion
 func (r *TempRepository) GetAllAvailable() ([]*Promotion, error) {
+	return r.GetAllByStatus(StatusActive)
+}
+
+// GetAllByStatus represent get all promotion in one of the statuses
+func (r *TempRepository) GetAllByStatus(statuses ...PromoStatus) ([]*Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	res := []*Promotion{}
 	for _, promo := range r.promoCollection {
-		if promo.Status == int64(1) {
-			res = append(res, promo)
+		for _, status := range statuses {
+			if promo.Status == status {
+				res = append(res, promo.clone())
+				break
+			}
 		}
 	}
 	return res, nil
@@ -71,22 +139,395 @@ func (r *TempRepository) GetAllAvailable() ([]*Promotion, error) {
 
 // Save represent save promotion repository
 func (r *TempRepository) Save(p *Promotion) error {
-	r.promoCollection = append(r.promoCollection, p)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, promo := range r.promoCollection {
+		if promo.Code == p.Code {
+			return ErrDuplicatePromoCode
+		}
+	}
+	r.promoCollection = append(r.promoCollection, p.clone())
+	r.saveDistribution(p.Distribution)
 	return nil
 }
 
+// Update represent update promotion repository, it fails with ErrPromoVersionConflict when
+// the promo has been updated since it was read and bumps the version of p on success
 func (r *TempRepository) Update(p *Promotion) error {
-	for _, promo := range r.promoCollection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, promo := range r.promoCollection {
 		if promo.ID == p.ID {
-			promo = p
+			if promo.Version != p.Version {
+				return ErrPromoVersionConflict
+			}
+			p.Version++
+			r.promoCollection[i] = p.clone()
+			r.saveDistribution(p.Distribution)
 			return nil
 		}
 	}
 	return ErrPromoNotFound
 }
 
+// UpdateMany represent update of several promotions at once, none is written when one of them conflicts
+func (r *TempRepository) UpdateMany(promos []*Promotion) error {
+	return r.SaveBooking(&BookingChange{Promos: promos})
+}
+
+// SaveBooking represent update of the promos and save of the holds and the redemptions of a booking at once, none
+// is written when a promo conflicts, a settled hold is no longer held or the booking has already redeemed one of
+// the promos
+func (r *TempRepository) SaveBooking(c *BookingChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	promos := c.Promos
+	indexes := make([]int, len(promos))
+	for i, p := range promos {
+		indexes[i] = -1
+		for j, promo := range r.promoCollection {
+			if promo.ID == p.ID {
+				if promo.Version != p.Version {
+					return ErrPromoVersionConflict
+				}
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] < 0 {
+			return ErrPromoNotFound
+		}
+	}
+
+	if c.Guest != "" && r.guestVersions[c.Guest] != c.GuestVersion {
+		return ErrPromoVersionConflict
+	}
+
+	settled := make([]int, len(c.Settled))
+	for i, h := range c.Settled {
+		j, err := r.heldIndex(h)
+		if err != nil {
+			return err
+		}
+		settled[i] = j
+	}
+
+	for _, rd := range c.Redemptions {
+		if r.redeemed(rd) {
+			return ErrBookingAlreadyRedeemed
+		}
+	}
+
+	for i, p := range promos {
+		p.Version++
+		r.promoCollection[indexes[i]] = p.clone()
+		r.saveDistribution(p.Distribution)
+	}
+	for _, h := range c.Holds {
+		hold := *h
+		r.holdCollection = append(r.holdCollection, &hold)
+	}
+	for i, h := range c.Settled {
+		hold := *h
+		r.holdCollection[settled[i]] = &hold
+	}
+	if c.Guest != "" {
+		if r.guestVersions == nil {
+			r.guestVersions = map[string]int64{}
+		}
+		r.guestVersions[c.Guest]++
+	}
+	for _, rd := range c.Redemptions {
+		redemption := *rd
+		r.redemptionCollection = append(r.redemptionCollection, &redemption)
+	}
+	return nil
+}
+
+// redeemed reports whether the booking of rd has redeemed its promo, the caller holds the lock
+func (r *TempRepository) redeemed(rd *Redemption) bool {
+	for _, redemption := range r.redemptionCollection {
+		if redemption.BookingRef == rd.BookingRef && redemption.PromoID == rd.PromoID {
+			return true
+		}
+	}
+	return false
+}
+
+// saveDistribution keeps the day of a distribution in the ledger, the caller holds the lock
+func (r *TempRepository) saveDistribution(d *PromoDistribution) {
+	if d == nil {
+		return
+	}
+
+	for i, day := range r.distributionLedger {
+		if day.PromoID == d.PromoID && day.Date == d.Date {
+			r.distributionLedger[i] = *d
+			return
+		}
+	}
+	r.distributionLedger = append(r.distributionLedger, *d)
+}
+
+// GetDistributionLedger represent get the distributed days of a promo from and to a date, ordered by date. An
+// empty from or to leaves the range open
+func (r *TempRepository) GetDistributionLedger(promoID uuid.UUID, from, to string) ([]*PromoDistribution, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []*PromoDistribution{}
+	for _, day := range r.distributionLedger {
+		if day.PromoID != promoID || (from != "" && day.Date < from) || (to != "" && day.Date > to) {
+			continue
+		}
+		d := day
+		res = append(res, &d)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Date < res[j].Date
+	})
+	return res, nil
+}
+
+// GetRedemptionsByBookingRef represent get redemptions of a booking
+func (r *TempRepository) GetRedemptionsByBookingRef(ref string) ([]*Redemption, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []*Redemption{}
+	for _, redemption := range r.redemptionCollection {
+		if redemption.BookingRef == ref {
+			rd := *redemption
+			res = append(res, &rd)
+		}
+	}
+	return res, nil
+}
+
+// GetRedemptionsByGuest represent get redemptions of a guest
+func (r *TempRepository) GetRedemptionsByGuest(guest string) ([]*Redemption, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []*Redemption{}
+	for _, redemption := range r.redemptionCollection {
+		if redemption.Guest == guest {
+			rd := *redemption
+			res = append(res, &rd)
+		}
+	}
+	return res, nil
+}
+
+// SaveRedemption represent save redemption repository
+func (r *TempRepository) SaveRedemption(rd *Redemption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.redeemed(rd) {
+		return ErrBookingAlreadyRedeemed
+	}
+	redemption := *rd
+	r.redemptionCollection = append(r.redemptionCollection, &redemption)
+	return nil
+}
+
+// GetHoldsByBookingRef represent get reservation holds of a booking
+func (r *TempRepository) GetHoldsByBookingRef(ref string) ([]*Hold, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []*Hold{}
+	for _, hold := range r.holdCollection {
+		if hold.BookingRef == ref {
+			h := *hold
+			res = append(res, &h)
+		}
+	}
+	return res, nil
+}
+
+// GetHoldsByGuest represent get reservation holds of a guest
+func (r *TempRepository) GetHoldsByGuest(guest string) ([]*Hold, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []*Hold{}
+	for _, hold := range r.holdCollection {
+		if hold.Guest == guest {
+			h := *hold
+			res = append(res, &h)
+		}
+	}
+	return res, nil
+}
+
+// GetGuestVersion represent get how often the guest has booked, it is zero for a guest who has not booked
+func (r *TempRepository) GetGuestVersion(guest string) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.guestVersions[guest], nil
+}
+
+// GetExpiredHolds represent get holds which are still held but expired at the given time
+func (r *TempRepository) GetExpiredHolds(t time.Time) ([]*Hold, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []*Hold{}
+	for _, hold := range r.holdCollection {
+		if hold.Status == HoldStatusHeld && hold.isExpired(t) {
+			h := *hold
+			res = append(res, &h)
+		}
+	}
+	return res, nil
+}
+
+// SaveHold represent save reservation hold repository
+func (r *TempRepository) SaveHold(h *Hold) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hold := *h
+	r.holdCollection = append(r.holdCollection, &hold)
+	return nil
+}
+
+// UpdateHold represent update reservation hold repository, it fails with ErrHoldNotHeld when the hold has been
+// confirmed, cancelled or expired since it was read
+func (r *TempRepository) UpdateHold(h *Hold) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, err := r.heldIndex(h)
+	if err != nil {
+		return err
+	}
+	updated := *h
+	r.holdCollection[i] = &updated
+	return nil
+}
+
+// heldIndex returns the index of the stored hold of h when it is still held, the caller holds the lock
+func (r *TempRepository) heldIndex(h *Hold) (int, error) {
+	for i, hold := range r.holdCollection {
+		if hold.ID == h.ID {
+			if hold.Status != HoldStatusHeld {
+				return -1, ErrHoldNotHeld
+			}
+			return i, nil
+		}
+	}
+	return -1, ErrHoldNotFound
+}
+
+// GetCalendarByID represent get blackout calendar by ID
+func (r *TempRepository) GetCalendarByID(id uuid.UUID) (*BlackoutCalendar, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, calendar := range r.calendarCollection {
+		if calendar.ID == id {
+			return calendar.clone(), nil
+		}
+	}
+	return nil, ErrCalendarNotFound
+}
+
+// GetAllCalendars represent get all blackout calendars
+func (r *TempRepository) GetAllCalendars() ([]*BlackoutCalendar, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []*BlackoutCalendar{}
+	for _, calendar := range r.calendarCollection {
+		res = append(res, calendar.clone())
+	}
+	return res, nil
+}
+
+// SaveCalendar represent save blackout calendar repository
+func (r *TempRepository) SaveCalendar(c *BlackoutCalendar) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calendarCollection = append(r.calendarCollection, c.clone())
+	return nil
+}
+
+// UpdateCalendar represent update blackout calendar repository
+func (r *TempRepository) UpdateCalendar(c *BlackoutCalendar) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, calendar := range r.calendarCollection {
+		if calendar.ID == c.ID {
+			r.calendarCollection[i] = c.clone()
+			return nil
+		}
+	}
+	return ErrCalendarNotFound
+}
+
+// DeleteCalendar represent delete blackout calendar repository
+func (r *TempRepository) DeleteCalendar(id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, calendar := range r.calendarCollection {
+		if calendar.ID == id {
+			r.calendarCollection = append(r.calendarCollection[:i], r.calendarCollection[i+1:]...)
+			return nil
+		}
+	}
+	return ErrCalendarNotFound
+}
+
+// AcquireLock represent take the lock by name until the given time, it fails when another owner holds a lock
+// which has not expired at now. The owner of the lock may take it again to extend it
+func (r *TempRepository) AcquireLock(name, owner string, now, until time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lock, ok := r.locks[name]; ok && lock.owner != owner && now.Before(lock.until) {
+		return false, nil
+	}
+
+	if r.locks == nil {
+		r.locks = map[string]jobLock{}
+	}
+	r.locks[name] = jobLock{owner: owner, until: until}
+	return true, nil
+}
+
+// ReleaseLock represent release the lock by name when owner holds it
+func (r *TempRepository) ReleaseLock(name, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lock, ok := r.locks[name]; ok && lock.owner == owner {
+		delete(r.locks, name)
+	}
+	return nil
+}
+
+// GetJobRun represent get the last run of a job
+func (r *TempRepository) GetJobRun(job string) (*JobRun, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	run, ok := r.jobRuns[job]
+	if !ok {
+		return nil, ErrJobRunNotFound
+	}
+	return &run, nil
+}
+
+// SaveJobRun represent save the last run of a job, it replaces the run before
+func (r *TempRepository) SaveJobRun(run *JobRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jobRuns == nil {
+		r.jobRuns = map[string]JobRun{}
+	}
+	r.jobRuns[run.Job] = *run
+	return nil
+}
+
 // NewRepository initiate Repository
 func NewRepository(p []*Promotion) (r Repository) {
-	r = &TempRepository{p}
+	promos := make([]*Promotion, 0, len(p))
+	for _, promo := range p {
+		promos = append(promos, promo.clone())
+	}
+	repo := &TempRepository{promoCollection: promos}
+	for _, promo := range promos {
+		repo.saveDistribution(promo.Distribution)
+	}
+	r = repo
 	return
 }
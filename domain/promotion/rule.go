@@ -0,0 +1,167 @@
+package promotion
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// Rule represent an eligibility check of a promo. A rule type is registered with RegisterRule and stored in the
+// rules of a promo as {"type": "min_night", "params": {"min": 2}}, the params are the JSON of the rule
+type Rule interface {
+	// Type returns the type the rule is registered as
+	Type() string
+	// Check returns nil when the room passes the rule, a *RuleFailure otherwise
+	Check(ctx *RuleContext) error
+	// Validate checks the params of the rule when the promo is saved
+	Validate() error
+}
+
+// RuleContext represent the room and the booking a rule is checked against, BookingTime is in the promo timezone
+type RuleContext struct {
+	Checkin     time.Time
+	BookingTime time.Time
+	Night       null.Int
+	Room        null.Int
+}
+
+var ruleTypes = map[string]func() Rule{}
+
+// RegisterRule makes a rule type available to promos, newRule returns an empty rule the params are decoded into.
+// It panics when the type is registered twice
+func RegisterRule(ruleType string, newRule func() Rule) {
+	if _, ok := ruleTypes[ruleType]; ok {
+		panic(fmt.Sprintf("rule type '%s' is already registered", ruleType))
+	}
+	ruleTypes[ruleType] = newRule
+}
+
+// RuleList represent the rules of a promo, stored as a JSON array
+type RuleList []Rule
+
+type ruleJSON struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (l RuleList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("null"), nil
+	}
+
+	rules := make([]ruleJSON, len(l))
+	for i, rule := range l {
+		params, err := json.Marshal(rule)
+		if err != nil {
+			return nil, err
+		}
+		rules[i] = ruleJSON{Type: rule.Type(), Params: params}
+	}
+	return json.Marshal(rules)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, it fails on a rule type which is not registered
+func (l *RuleList) UnmarshalJSON(b []byte) error {
+	var rules []ruleJSON
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return err
+	}
+	if rules == nil {
+		*l = nil
+		return nil
+	}
+
+	res := make(RuleList, len(rules))
+	for i, r := range rules {
+		newRule, ok := ruleTypes[r.Type]
+		if !ok {
+			return fmt.Errorf("unknown rule type '%s'", r.Type)
+		}
+
+		res[i] = newRule()
+		if len(r.Params) > 0 && string(r.Params) != "null" {
+			if err := json.Unmarshal(r.Params, res[i]); err != nil {
+				return fmt.Errorf("invalid params of rule '%s': %v", r.Type, err)
+			}
+		}
+	}
+
+	*l = res
+	return nil
+}
+
+// Value implements the driver Valuer interface
+func (l RuleList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the Scanner interface
+func (l *RuleList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return l.UnmarshalJSON(v)
+	case string:
+		return l.UnmarshalJSON([]byte(v))
+	}
+
+	return fmt.Errorf("can not scan %T into RuleList", value)
+}
+
+// clone returns a copy of the list which shares no pointers with l, rules are copied through their JSON
+func (l RuleList) clone() RuleList {
+	if l == nil {
+		return nil
+	}
+
+	var res RuleList
+	if b, err := json.Marshal(l); err == nil && json.Unmarshal(b, &res) == nil {
+		return res
+	}
+	return append(RuleList{}, l...)
+}
+
+// set replaces the rules of the same type as rule with rule, it is added when the list has none
+func (l RuleList) set(rule Rule) RuleList {
+	res := RuleList{}
+	found := false
+	for _, r := range l {
+		if r.Type() != rule.Type() {
+			res = append(res, r)
+			continue
+		}
+		if !found {
+			res = append(res, rule)
+			found = true
+		}
+	}
+
+	if !found {
+		res = append(res, rule)
+	}
+	return res
+}
+
+// validate checks the params of every rule
+func (l RuleList) validate() error {
+	for _, rule := range l {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
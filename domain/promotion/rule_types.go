@@ -0,0 +1,189 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	RuleMinNight      = "min_night"
+	RuleMinRoom       = "min_room"
+	RuleCheckinDay    = "checkin_day"
+	RuleBookingDay    = "booking_day"
+	RuleBookingWindow = "booking_window"
+	RuleDateRange     = "date_range"
+)
+
+func init() {
+	RegisterRule(RuleMinNight, func() Rule { return &MinNightRule{} })
+	RegisterRule(RuleMinRoom, func() Rule { return &MinRoomRule{} })
+	RegisterRule(RuleCheckinDay, func() Rule { return &CheckinDayRule{} })
+	RegisterRule(RuleBookingDay, func() Rule { return &BookingDayRule{} })
+	RegisterRule(RuleBookingWindow, func() Rule { return &BookingWindowRule{} })
+	RegisterRule(RuleDateRange, func() Rule { return &DateRangeRule{} })
+}
+
+// MinNightRule represent the least nights a room has to stay
+type MinNightRule struct {
+	Min int64 `json:"min"`
+}
+
+func (r *MinNightRule) Type() string {
+	return RuleMinNight
+}
+
+func (r *MinNightRule) Check(ctx *RuleContext) error {
+	if !ctx.Night.Valid {
+		return newFailure(FailureMinNight, "This promo apply min night", map[string]interface{}{
+			"required": r.Min,
+		})
+	}
+
+	if ctx.Night.Int64 < r.Min {
+		return newFailure(FailureMinNight, "Min Night rule is failed", map[string]interface{}{
+			"required": r.Min,
+			"actual":   ctx.Night.Int64,
+		})
+	}
+
+	return nil
+}
+
+func (r *MinNightRule) Validate() error {
+	if r.Min < 0 {
+		return errors.New("Min Night can not be negative")
+	}
+	return nil
+}
+
+// MinRoomRule represent the least rooms a booking has to take
+type MinRoomRule struct {
+	Min int64 `json:"min"`
+}
+
+func (r *MinRoomRule) Type() string {
+	return RuleMinRoom
+}
+
+func (r *MinRoomRule) Check(ctx *RuleContext) error {
+	if !ctx.Room.Valid {
+		return newFailure(FailureMinRoom, "This promo apply min room", map[string]interface{}{
+			"required": r.Min,
+		})
+	}
+
+	if ctx.Room.Int64 < r.Min {
+		return newFailure(FailureMinRoom, "Min Room rule is failed", map[string]interface{}{
+			"required": r.Min,
+			"actual":   ctx.Room.Int64,
+		})
+	}
+
+	return nil
+}
+
+func (r *MinRoomRule) Validate() error {
+	if r.Min < 0 {
+		return errors.New("Min Room can not be negative")
+	}
+	return nil
+}
+
+// CheckinDayRule represent the weekdays a room may check in on
+type CheckinDayRule struct {
+	Days string `json:"days"`
+}
+
+func (r *CheckinDayRule) Type() string {
+	return RuleCheckinDay
+}
+
+func (r *CheckinDayRule) Check(ctx *RuleContext) error {
+	checkinDays, err := utils.ParseWeekdays(r.Days)
+	if err != nil {
+		return newFailure(FailureInvalidPromo, "Promo Checkin Day is Invalid", nil)
+	}
+
+	if !checkinDays.Contains(ctx.Checkin.Weekday()) {
+		return newFailure(FailureCheckinDay, "checkin time rule is failed", map[string]interface{}{
+			"days":   r.Days,
+			"actual": ctx.Checkin.Weekday().String(),
+		})
+	}
+
+	return nil
+}
+
+func (r *CheckinDayRule) Validate() error {
+	if _, err := utils.ParseWeekdays(r.Days); err != nil {
+		return fmt.Errorf("Checkin Days is invalid: %v", err)
+	}
+	return nil
+}
+
+// BookingDayRule represent the weekdays a booking may be made on
+type BookingDayRule struct {
+	Days string `json:"days"`
+}
+
+func (r *BookingDayRule) Type() string {
+	return RuleBookingDay
+}
+
+func (r *BookingDayRule) Check(ctx *RuleContext) error {
+	bookingDays, err := utils.ParseWeekdays(r.Days)
+	if err != nil {
+		return newFailure(FailureInvalidPromo, "Promo Booking Day is Invalid", nil)
+	}
+
+	if !bookingDays.Contains(ctx.BookingTime.Weekday()) {
+		return newFailure(FailureBookingDay, "booking time rule is failed", map[string]interface{}{
+			"days":   r.Days,
+			"actual": ctx.BookingTime.Weekday().String(),
+		})
+	}
+
+	return nil
+}
+
+func (r *BookingDayRule) Validate() error {
+	if _, err := utils.ParseWeekdays(r.Days); err != nil {
+		return fmt.Errorf("Booking Days is invalid: %v", err)
+	}
+	return nil
+}
+
+// DateRangeRule represent the period a booking may be made in, every promo checks its own start and end date
+type DateRangeRule struct {
+	Start null.Time `json:"start"`
+	End   null.Time `json:"end"`
+}
+
+func (r *DateRangeRule) Type() string {
+	return RuleDateRange
+}
+
+func (r *DateRangeRule) Check(ctx *RuleContext) error {
+	if !r.Start.Valid || !r.End.Valid {
+		return nil
+	}
+
+	if ctx.BookingTime.After(r.Start.Time) && ctx.BookingTime.Before(r.End.Time) {
+		return nil
+	}
+
+	return newFailure(FailureDateRange, "Promo is not started or has been ended", map[string]interface{}{
+		"startDate": r.Start.Time,
+		"endDate":   r.End.Time,
+	})
+}
+
+func (r *DateRangeRule) Validate() error {
+	if r.Start.Valid && r.End.Valid && r.Start.Time.After(r.End.Time) {
+		return errors.New("End Date must greather than Start Date")
+	}
+	return nil
+}
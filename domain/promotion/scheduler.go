@@ -0,0 +1,200 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/guregu/null.v3"
+)
+
+// DefaultDistributionSchedule distributes the daily quota at midnight of the promo timezone
+const DefaultDistributionSchedule = "0 0 * * *"
+
+// JobDistribution is the name the scheduled distribution is locked and recorded as
+const JobDistribution = "distribution"
+
+// distributionLockTTL bounds how long a crashed instance keeps the distribution locked
+const distributionLockTTL = 5 * time.Minute
+
+var ErrJobLocked = errors.New("Job is running on another instance")
+var ErrJobRunNotFound = errors.New("Job Run Not Found")
+
+var errDistributionNotDue = errors.New("distribution is not due")
+
+var defaultSchedule, _ = ParseSchedule(DefaultDistributionSchedule)
+
+// Schedule represent when a job runs, Next returns the first run after t in the location of t
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a standard 5 field cron expression such as "0 0 * * *", an empty spec is the default
+// distribution schedule
+func ParseSchedule(spec string) (Schedule, error) {
+	if spec == "" {
+		spec = DefaultDistributionSchedule
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Schedule '%s' is invalid: %v", spec, err)
+	}
+	return schedule, nil
+}
+
+// JobRun represent the last run of a scheduled job
+type JobRun struct {
+	Job         string    `db:"job" json:"job"`
+	Instance    string    `db:"instance" json:"instance"`
+	StartedAt   time.Time `db:"started_at" json:"startedAt"`
+	FinishedAt  time.Time `db:"finished_at" json:"finishedAt"`
+	Distributed int64     `db:"distributed" json:"distributed"`
+	Error       string    `db:"error" json:"error"`
+}
+
+// SchedulerStatus represent the state of the distribution scheduler, NextRunAt is the earliest next distribution
+// of the available promos
+type SchedulerStatus struct {
+	Job       string    `json:"job"`
+	Instance  string    `json:"instance"`
+	LastRun   *JobRun   `json:"lastRun"`
+	NextRunAt null.Time `json:"nextRunAt"`
+}
+
+func defaultInstance() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "promo-api"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// distributionDue tells whether the daily quota of the promo has to be distributed at t, a promo which has
+// never been distributed is due
+func (p *Promotion) distributionDue(t time.Time) bool {
+	return p.Distribution == nil || !p.Distribution.NextRunAt.Valid || !t.Before(p.Distribution.NextRunAt.Time)
+}
+
+// nextDistribution returns when the daily quota of the promo is distributed after t, the schedule runs in the
+// promo timezone
+func (s *Service) nextDistribution(p *Promotion, t time.Time) time.Time {
+	return s.schedule.Next(t.In(p.location())).UTC()
+}
+
+// DistributeDue distributes the daily quota of every available promo whose next distribution has come. A
+// distribution missed while the service was down is caught up on the next run. It returns how many promos
+// were distributed
+func (s *Service) DistributeDue() (int64, error) {
+	promos, err := s.repo.GetAllAvailable()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get available promo: %v", err)
+	}
+
+	now := s.clock.Now()
+	distributed := int64(0)
+	for _, promo := range promos {
+		if !promo.distributionDue(now) {
+			continue
+		}
+
+		err = s.modifyPromotion(promo.ID, func(p *Promotion) error {
+			// another run may have distributed the promo since it was listed
+			if !p.distributionDue(now) {
+				return errDistributionNotDue
+			}
+			s.distribute(p)
+			return nil
+		})
+		if err == errDistributionNotDue {
+			continue
+		}
+		if err != nil {
+			return distributed, err
+		}
+		distributed++
+	}
+
+	return distributed, nil
+}
+
+// RunScheduledDistribution runs DistributeDue under the distribution lock and records the run, it fails with
+// ErrJobLocked when another instance holds the lock
+func (s *Service) RunScheduledDistribution() (*JobRun, error) {
+	started := s.clock.Now()
+	locked, err := s.repo.AcquireLock(JobDistribution, s.instance, started, started.Add(distributionLockTTL))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lock job: %v", err)
+	}
+	if !locked {
+		return nil, ErrJobLocked
+	}
+	defer func() {
+		if err := s.repo.ReleaseLock(JobDistribution, s.instance); err != nil {
+			log.Println("Scheduler:", err)
+		}
+	}()
+
+	run := &JobRun{Job: JobDistribution, Instance: s.instance, StartedAt: started}
+	run.Distributed, err = s.DistributeDue()
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.FinishedAt = s.clock.Now()
+
+	if saveErr := s.repo.SaveJobRun(run); saveErr != nil {
+		return run, fmt.Errorf("Failed to save job run: %v", saveErr)
+	}
+	return run, err
+}
+
+// StartScheduler runs RunScheduledDistribution at once, to catch up a distribution missed while the service was
+// down, and then every interval until stop is closed. The interval is how often due promos are looked for,
+// the schedule decides when a promo is due
+func (s *Service) StartScheduler(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := s.RunScheduledDistribution(); err != nil && err != ErrJobLocked {
+				log.Println("Scheduler:", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// SchedulerStatus represent the last distribution run of any instance and the next distribution
+func (s *Service) SchedulerStatus() (*SchedulerStatus, error) {
+	status := &SchedulerStatus{Job: JobDistribution, Instance: s.instance}
+
+	run, err := s.repo.GetJobRun(JobDistribution)
+	if err != nil && err != ErrJobRunNotFound {
+		return nil, fmt.Errorf("Failed to get job run: %v", err)
+	}
+	status.LastRun = run
+
+	promos, err := s.repo.GetAllAvailable()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get available promo: %v", err)
+	}
+	for _, promo := range promos {
+		if promo.Distribution == nil || !promo.Distribution.NextRunAt.Valid {
+			continue
+		}
+		next := promo.Distribution.NextRunAt
+		if !status.NextRunAt.Valid || next.Time.Before(status.NextRunAt.Time) {
+			status.NextRunAt = next
+		}
+	}
+
+	return status, nil
+}
@@ -3,104 +3,771 @@ package promotion
 import (
 	"errors"
 	"fmt"
-	"math"
+	"io"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/chandrafortuna/simple-promotion-api/utils"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
 )
 
 // Service represent promotion service
 type Service struct {
-	repo Repository
+	repo       Repository
+	stackOrder StackOrder
+	rounding   Rounding
+	clock      Clock
+	// schedule tells when the daily quota is distributed, instance names this service in job locks
+	schedule Schedule
+	instance string
+	// lock serializes the booking checks of this instance, the promo quota itself is guarded by the promo version
+	lock *sync.Mutex
 }
 
 // NewService represent promotion service constructor
 func NewService(r Repository) Service {
 	return Service{
-		repo: r,
+		repo:       r,
+		stackOrder: StackOrderPercentageFirst,
+		rounding:   RoundHalfUp,
+		clock:      systemClock,
+		schedule:   defaultSchedule,
+		instance:   defaultInstance(),
+		lock:       &sync.Mutex{},
 	}
 }
 
-// ApplyPromotion represent apply promotion of the service
+// WithStackOrder returns a copy of the service which applies stacked promos in the given order
+func (s Service) WithStackOrder(order StackOrder) Service {
+	s.stackOrder = order
+	return s
+}
+
+// WithRounding returns a copy of the service which rounds every promo discount by the rule
+func (s Service) WithRounding(rounding Rounding) Service {
+	s.rounding = rounding
+	return s
+}
+
+// WithClock returns a copy of the service which reads the current time from the clock
+func (s Service) WithClock(clock Clock) Service {
+	s.clock = clock
+	return s
+}
+
+// WithSchedule returns a copy of the service which distributes the daily quota by the schedule
+func (s Service) WithSchedule(schedule Schedule) Service {
+	s.schedule = schedule
+	return s
+}
+
+// WithInstance returns a copy of the service which takes job locks under the instance name
+func (s Service) WithInstance(instance string) Service {
+	s.instance = instance
+	return s
+}
+
+// ApplyPromotion represent apply promotion of the service, the booking time of the request is ignored
 func (s *Service) ApplyPromotion(req ApplyPromoRequest) (pr *ApplyPromoResponse, err error) {
-	//getPromoByCode
-	promoExists, err := s.repo.ExistsByCode(req.Code)
+	return s.applyPromotion(req, s.clock.Now())
+}
+
+// PreviewPromotion represent apply promotion as if the booking is made at the booking time of the request, now
+// when it is not given. It is meant for admin simulations, nothing is redeemed
+func (s *Service) PreviewPromotion(req ApplyPromoRequest) (*ApplyPromoResponse, error) {
+	bookingTime := s.clock.Now()
+	if req.BookingTime.Valid {
+		t, err := utils.ParseTimeFromString(req.BookingTime.String)
+		if err != nil {
+			return nil, ErrInvalidBookingTime
+		}
+		bookingTime = t
+	}
+
+	return s.applyPromotion(req, bookingTime)
+}
+
+func (s *Service) applyPromotion(req ApplyPromoRequest, bookingTime time.Time) (*ApplyPromoResponse, error) {
+	promos, err := s.getPromotions(req.codes())
+	if err != nil {
+		return nil, err
+	}
+
+	pr, _, err := s.calculate(promos, req, bookingTime)
+	return pr, err
+}
+
+// BestPromotion represent best promotion of the service, it picks the combination of public and
+// auto apply promos which saves the guest the most without the guest giving a code
+func (s *Service) BestPromotion(req ApplyPromoRequest) (*BestPromoResponse, error) {
+	promos, err := s.repo.GetAllAvailable()
+	if err != nil {
+		return nil, errors.New("Failed to get available promo")
+	}
+
+	bookingTime := s.clock.Now()
+	rejected := []*RejectedPromo{}
+	candidates := []*candidate{}
+	for _, promo := range promos {
+		if !promo.isCandidate() {
+			continue
+		}
+
+		pr, applied, err := s.calculate([]*Promotion{promo}, req, bookingTime)
+		if err == ErrCurrencyMismatch {
+			rejected = append(rejected, &RejectedPromo{PromoID: promo.ID, Code: promo.Code, Reason: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if applied[promo.ID] == 0 {
+			reason := pr.promoMessage(promo.ID)
+			if reason == "" {
+				reason = ErrPromoNotApplied.Error()
+			}
+			rejected = append(rejected, &RejectedPromo{PromoID: promo.ID, Code: promo.Code, Reason: reason})
+			continue
+		}
+
+		candidates = append(candidates, &candidate{promo: promo, saving: pr.PromoPrice})
+	}
+
+	res := &BestPromoResponse{Codes: []string{}, Rejected: rejected}
+	if len(candidates) == 0 {
+		pr, _, err := s.calculate(nil, req, bookingTime)
+		if err != nil {
+			return nil, err
+		}
+		res.Result = pr
+		return res, nil
+	}
+
+	var best []*Promotion
+	for _, combo := range stackCombinations(candidates) {
+		sortStack(combo, s.stackOrder)
+		pr, _, err := s.calculate(combo, req, bookingTime)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Result == nil || pr.PromoPrice > res.Result.PromoPrice ||
+			(pr.PromoPrice == res.Result.PromoPrice && len(combo) < len(best)) {
+			best = combo
+			res.Result = pr
+		}
+	}
+
+	chosen := map[uuid.UUID]bool{}
+	for _, promo := range best {
+		chosen[promo.ID] = true
+		res.Codes = append(res.Codes, promo.Code)
+	}
+
+	for _, c := range candidates {
+		if !chosen[c.promo.ID] {
+			res.Rejected = append(res.Rejected, &RejectedPromo{
+				PromoID: c.promo.ID,
+				Code:    c.promo.Code,
+				Reason:  rejectionReason(c.promo, best, c.saving),
+			})
+		}
+	}
+
+	return res, nil
+}
+
+// RedeemPromotion represent redeem promotion of the service, it consumes the quota of every promo applied to the booking
+func (s *Service) RedeemPromotion(req RedeemPromoRequest) (*RedeemPromoResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	var pr *ApplyPromoResponse
+	var redemptions []*Redemption
+	err := retryOnConflict(func() (err error) {
+		var change *BookingChange
+		change, pr, err = s.prepareBooking(req, now)
+		if err != nil {
+			return err
+		}
+
+		redemptions = []*Redemption{}
+		for _, promo := range change.Promos {
+			if err = promo.consume(now); err != nil {
+				return err
+			}
+
+			uid, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+
+			redemptions = append(redemptions, &Redemption{
+				ID:            uid,
+				PromoID:       promo.ID,
+				Code:          promo.Code,
+				BookingRef:    req.BookingRef,
+				Guest:         req.guest(),
+				Currency:      pr.Currency,
+				OriginalPrice: pr.OriginalPrice,
+				PromoPrice:    pr.promoSaving(promo.ID),
+				FinalPrice:    pr.FinalPrice,
+				CreatedAt:     now,
+			})
+		}
+
+		// the quota and the redemptions are written together, a booking never consumes quota it has no
+		// redemption of
+		change.Redemptions = redemptions
+		return s.saveBooking(change)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedeemPromoResponse{
+		Redemptions: redemptions,
+		Result:      pr,
+	}, nil
+}
+
+// ReservePromotion represent reserve promotion of the service, it holds the quota of every promo applied to the booking until the TTL passes
+func (s *Service) ReservePromotion(req ReservePromoRequest) (*ReservePromoResponse, error) {
+	ttl, err := req.ttl()
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	var pr *ApplyPromoResponse
+	var holds []*Hold
+	err = retryOnConflict(func() (err error) {
+		var change *BookingChange
+		change, pr, err = s.prepareBooking(req.RedeemPromoRequest, now)
+		if err != nil {
+			return err
+		}
+
+		holds = []*Hold{}
+		for _, promo := range change.Promos {
+			if err = promo.hold(now); err != nil {
+				return err
+			}
+
+			uid, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+
+			holds = append(holds, &Hold{
+				ID:            uid,
+				PromoID:       promo.ID,
+				Code:          promo.Code,
+				BookingRef:    req.BookingRef,
+				Guest:         req.guest(),
+				Status:        HoldStatusHeld,
+				Currency:      pr.Currency,
+				OriginalPrice: pr.OriginalPrice,
+				PromoPrice:    pr.promoSaving(promo.ID),
+				FinalPrice:    pr.FinalPrice,
+				CreatedAt:     now,
+				ExpiresAt:     now.Add(ttl),
+			})
+		}
+
+		change.Holds = holds
+		return s.saveBooking(change)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReservePromoResponse{
+		Holds:  holds,
+		Result: pr,
+	}, nil
+}
+
+// ConfirmReservation represent confirm reservation of the service, it turns the held quota of a booking into redemptions
+func (s *Service) ConfirmReservation(bookingRef string) (*ConfirmReservationResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	holds, err := s.activeHolds(bookingRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// a booking is confirmed with all of its promos or none of them, once one hold has expired every hold of
+	// the booking gives its quota back
+	now := s.clock.Now()
+	for _, hold := range holds {
+		if !hold.isExpired(now) {
+			continue
+		}
+
+		for _, hold := range holds {
+			if err = s.releaseHold(hold, HoldStatusExpired); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrHoldExpired
+	}
+
+	var redemptions []*Redemption
+	err = retryOnConflict(func() error {
+		promos := map[uuid.UUID]*Promotion{}
+		change := &BookingChange{}
+		redemptions = []*Redemption{}
+		for _, hold := range holds {
+			promo, ok := promos[hold.PromoID]
+			if !ok {
+				promo, err = s.readPromotion(hold.PromoID)
+				if err != nil {
+					return err
+				}
+				promos[hold.PromoID] = promo
+				change.Promos = append(change.Promos, promo)
+			}
+			promo.confirm()
+
+			settled := *hold
+			settled.Status = HoldStatusConfirmed
+			change.Settled = append(change.Settled, &settled)
+
+			uid, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+
+			redemptions = append(redemptions, &Redemption{
+				ID:            uid,
+				PromoID:       hold.PromoID,
+				Code:          hold.Code,
+				BookingRef:    hold.BookingRef,
+				Guest:         hold.Guest,
+				Currency:      hold.Currency,
+				OriginalPrice: hold.OriginalPrice,
+				PromoPrice:    hold.PromoPrice,
+				FinalPrice:    hold.FinalPrice,
+				CreatedAt:     now,
+			})
+		}
+
+		change.Redemptions = redemptions
+		return s.saveBooking(change)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfirmReservationResponse{Redemptions: redemptions}, nil
+}
+
+// CancelReservation represent cancel reservation of the service, it returns the held quota of a booking to the balance
+func (s *Service) CancelReservation(bookingRef string) ([]*Hold, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	holds, err := s.activeHolds(bookingRef)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hold := range holds {
+		if err = s.releaseHold(hold, HoldStatusCancelled); err != nil {
+			return nil, err
+		}
+	}
+
+	return holds, nil
+}
+
+// ExpireHolds represent expire holds of the service, it returns the quota of every expired hold to the balance
+func (s *Service) ExpireHolds() ([]*Hold, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	holds, err := s.repo.GetExpiredHolds(s.clock.Now())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get expired reservations: %v", err)
+	}
+
+	res := []*Hold{}
+	for _, hold := range holds {
+		err = s.releaseHold(hold, HoldStatusExpired)
+		if err == ErrHoldNotHeld {
+			// the hold has been settled by another instance since it was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, hold)
+	}
+
+	return res, nil
+}
+
+// StartSweeper runs ExpireHolds and RefreshStatuses every interval until stop is closed
+func (s *Service) StartSweeper(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.ExpireHolds(); err != nil {
+					log.Println("Sweeper:", err)
+				}
+				if err := s.RefreshStatuses(); err != nil {
+					log.Println("Sweeper:", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// prepareBooking checks the booking has not used a promo yet and prices it, it returns the change of the booking
+// with the promos which apply to at least one room. The guest version is read before the guest history, a booking
+// of the guest saved in between makes the change conflict
+func (s *Service) prepareBooking(req RedeemPromoRequest, bookingTime time.Time) (*BookingChange, *ApplyPromoResponse, error) {
+	if req.BookingRef == "" {
+		return nil, nil, ErrBookingRefRequired
+	}
+
+	redemptions, err := s.repo.GetRedemptionsByBookingRef(req.BookingRef)
+	if err != nil {
+		return nil, nil, errors.New("Failed to get booking redemptions")
+	}
+
+	if len(redemptions) > 0 {
+		return nil, nil, ErrBookingAlreadyRedeemed
+	}
+
+	holds, err := s.repo.GetHoldsByBookingRef(req.BookingRef)
+	if err != nil {
+		return nil, nil, errors.New("Failed to get booking reservations")
+	}
+
+	for _, hold := range holds {
+		if hold.Status == HoldStatusHeld {
+			return nil, nil, ErrBookingAlreadyReserved
+		}
+	}
+
+	promos, err := s.getPromotions(req.codes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	change := &BookingChange{Guest: req.guest()}
+	if change.Guest != "" {
+		if change.GuestVersion, err = s.repo.GetGuestVersion(change.Guest); err != nil {
+			return nil, nil, fmt.Errorf("Failed to get guest: %v", err)
+		}
+	}
+
+	pr, applied, err := s.calculate(promos, req.ApplyPromoRequest, bookingTime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, promo := range promos {
+		if applied[promo.ID] > 0 {
+			change.Promos = append(change.Promos, promo)
+		}
+	}
+
+	if len(change.Promos) == 0 {
+		return nil, nil, ErrPromoNotApplied
+	}
+
+	return change, pr, nil
+}
+
+func (s *Service) activeHolds(bookingRef string) ([]*Hold, error) {
+	holds, err := s.repo.GetHoldsByBookingRef(bookingRef)
+	if err != nil {
+		return nil, errors.New("Failed to get booking reservations")
+	}
+
+	res := []*Hold{}
+	for _, hold := range holds {
+		if hold.Status == HoldStatusHeld {
+			res = append(res, hold)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, ErrHoldNotFound
+	}
+
+	return res, nil
+}
+
+// releaseHold gives the quota of the hold back and settles the hold with status in one write, it fails with
+// ErrHoldNotHeld when the hold has been settled since it was read
+func (s *Service) releaseHold(hold *Hold, status HoldStatus) error {
+	settled := *hold
+	settled.Status = status
+	err := retryOnConflict(func() error {
+		promo, err := s.readPromotion(hold.PromoID)
+		if err != nil {
+			return err
+		}
+
+		promo.release(hold.CreatedAt)
+		return s.saveBooking(&BookingChange{Promos: []*Promotion{promo}, Settled: []*Hold{&settled}})
+	})
+	if err != nil {
+		return err
+	}
+
+	hold.Status = status
+	return nil
+}
+
+// modifyPromotion reads the promo, applies fn and writes it back, retrying on a version conflict
+func (s *Service) modifyPromotion(id uuid.UUID, fn func(*Promotion) error) error {
+	return retryOnConflict(func() error {
+		promo, err := s.readPromotion(id)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(promo); err != nil {
+			return err
+		}
+
+		return s.updatePromotion(promo)
+	})
+}
+
+// readPromotion gets the promo of id to be modified
+func (s *Service) readPromotion(id uuid.UUID) (*Promotion, error) {
+	promo, err := s.repo.GetPromotionByID(id)
+	if err != nil && err != ErrPromoNotFound {
+		return nil, fmt.Errorf("Failed to get promo: %v", err)
+	}
+	return promo, err
+}
+
+func (s *Service) updatePromotion(promo *Promotion) error {
+	promo.refreshStatus(s.clock.Now())
+	err := s.repo.Update(promo)
+	if err != nil && err != ErrPromoVersionConflict {
+		return fmt.Errorf("Failed to update promo: %v", err)
+	}
+	return err
+}
+
+// saveBooking writes the promos and the records of a booking all together or none of them
+func (s *Service) saveBooking(change *BookingChange) error {
+	now := s.clock.Now()
+	for _, promo := range change.Promos {
+		promo.refreshStatus(now)
+	}
+
+	err := s.repo.SaveBooking(change)
+	switch err {
+	case nil, ErrPromoVersionConflict, ErrPromoNotFound, ErrBookingAlreadyRedeemed, ErrHoldNotFound, ErrHoldNotHeld:
+		return err
+	}
+	return fmt.Errorf("Failed to save booking: %v", err)
+}
+
+// maxConflictRetries bounds how often an optimistic update is attempted against a fresh read
+const maxConflictRetries = 5
+
+func retryOnConflict(fn func() error) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		if err = fn(); err != ErrPromoVersionConflict {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *Service) getPromotion(code string) (*Promotion, error) {
+	promoExists, err := s.repo.ExistsByCode(code)
 	if err != nil {
 		return nil, errors.New("Failed to get existance of promo")
 	}
 
 	if !promoExists {
-		return nil, errors.New("Promo is Not Exists")
+		return nil, ErrPromoNotFound
 	}
 
-	promo, err := s.repo.GetPromotionByCode(req.Code)
+	promo, err := s.repo.GetPromotionByCode(code)
 	if err != nil {
 		return nil, errors.New("Failed to get promo")
 	}
 
-	bookingTime := time.Now()
-	var rooms []*RoomResponse
-	totalPromo := float64(0)
-	totalPrice := float64(0)
+	return promo, nil
+}
+
+// getPromotions gets the promos of the codes in the order they are stacked
+func (s *Service) getPromotions(codes []string) ([]*Promotion, error) {
+	promos := []*Promotion{}
+	for _, code := range codes {
+		promo, err := s.getPromotion(code)
+		if err != nil {
+			return nil, err
+		}
+		promos = append(promos, promo)
+	}
+
+	if err := validateStack(promos); err != nil {
+		return nil, err
+	}
+
+	sortStack(promos, s.stackOrder)
+	return promos, nil
+}
+
+// calculate prices every room against the stacked promos, every promo discounts the price left by the promo before it.
+// Order promos discount the booking total once every room promo has been applied.
+// It returns how many rooms every promo applied to
+func (s *Service) calculate(promos []*Promotion, req ApplyPromoRequest, bookingTime time.Time) (*ApplyPromoResponse, map[uuid.UUID]int, error) {
+	currency, err := normalizeCurrency(req.Currency)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, promo := range promos {
+		promo.refreshStatus(bookingTime)
+	}
+
+	if err = s.loadCalendars(promos); err != nil {
+		return nil, nil, err
+	}
+
+	if err = s.loadGuest(promos, req.guest(), bookingTime); err != nil {
+		return nil, nil, err
+	}
+
+	roomPromos, orderPromos := splitScope(promos)
+	stacked := len(promos) > 1
+	rooms := []*RoomResponse{}
+	checkins := []time.Time{}
+	failures := [][]*RuleFailure{}
+	applied := map[uuid.UUID]int{}
 	for _, room := range req.Rooms {
 		parsedDate, err := utils.ParseTimeFromString(room.Date)
-		promoPrice := float64(0)
-		message := ""
 		if err != nil {
-			return nil, errors.New("Invalid Date")
+			return nil, nil, ErrInvalidRoomDate
 		}
-		err = promo.ApplyRule(parsedDate, bookingTime, room.Night, room.Qty)
-		if err != nil {
-			message = fmt.Sprintf("Promo not applied: %v", err)
-		} else {
-			promoPrice, err = promo.CalculatePromo(room.Price)
-			if err != nil {
-				return nil, fmt.Errorf("Promo calculation failed: %v", err)
-			}
+		if err = room.validate(); err != nil {
+			return nil, nil, err
 		}
 
-		if promoPrice == 0 {
-			promoPrice = room.Price
+		promoPrice := Money{Amount: room.Price, Currency: currency}
+		nights := newNightResponses(stayNights(parsedDate, room.Night), room.Price)
+		breakdowns := []*PromoBreakdown{}
+		roomFailures := []*RuleFailure{}
+		for _, promo := range roomPromos {
+			breakdown := &PromoBreakdown{
+				PromoID: promo.ID,
+				Code:    promo.Code,
+			}
+
+			// every rule is checked so the guest sees all it takes for the promo to apply
+			trace, err := promo.TraceRule(parsedDate, bookingTime, room.Night, room.Qty)
+			breakdown.Trace = trace
+			failures := RuleFailures{}.add(err)
+			eligible, err := promo.stayRule(nights)
+			failures = failures.add(err)
+			err = promo.minSpendRule(Money{Amount: room.Price, Currency: currency})
+			if err == ErrCurrencyMismatch {
+				return nil, nil, err
+			}
+			failures = failures.add(err)
+
+			if len(failures) > 0 {
+				breakdown.Message = failures.Error()
+				roomFailures = append(roomFailures, promoFailures(promo, failures)...)
+			} else {
+				newPrice, err := promo.CalculatePromo(promoPrice, s.rounding)
+				if err == ErrCurrencyMismatch {
+					return nil, nil, err
+				}
+				if err != nil {
+					return nil, nil, fmt.Errorf("Promo calculation failed: %v", err)
+				}
+				// the discount of the whole stay is prorated to the nights the promo applies to
+				breakdown.Saving = spreadSaving(nights, promoPrice.Amount-newPrice.Amount, eligible)
+				promoPrice.Amount -= breakdown.Saving
+				applied[promo.ID]++
+			}
+
+			breakdowns = append(breakdowns, breakdown)
 		}
 
-		res := &RoomResponse{
+		rooms = append(rooms, &RoomResponse{
 			Date:       parsedDate,
 			Room:       room.Room,
 			Price:      room.Price,
 			Night:      room.Night,
 			Qty:        room.Qty,
-			PromoPrice: promoPrice,
-			Saving:     room.Price - promoPrice,
-			Message:    message,
-		}
+			PromoPrice: promoPrice.Amount,
+			Promos:     breakdowns,
+			Nights:     nights,
+		})
+		checkins = append(checkins, parsedDate)
+		failures = append(failures, roomFailures)
+	}
+
+	pr := &ApplyPromoResponse{
+		Rooms:    rooms,
+		Currency: currency,
+	}
 
-		totalPromo += (room.Price - promoPrice)
-		totalPrice += room.Price
-		rooms = append(rooms, res)
+	if len(orderPromos) > 0 {
+		pr.Order, err = s.applyOrderPromos(orderPromos, req, pr, checkins, failures, bookingTime, applied, stacked)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	pr = &ApplyPromoResponse{
-		Rooms:         rooms,
-		PromoPrice:    totalPromo,
-		FinalPrice:    totalPrice - totalPromo,
-		OriginalPrice: totalPrice,
+	for i, room := range rooms {
+		room.Saving = room.Price - room.PromoPrice
+		room.Failures = failures[i]
+		room.Message = failureMessage(failures[i], stacked)
+
+		pr.PromoPrice += room.Saving
+		pr.OriginalPrice += room.Price
 	}
+	pr.FinalPrice = pr.OriginalPrice - pr.PromoPrice
 
-	return pr, nil
+	return pr, applied, nil
 }
 
 // PromoDistribution represent promotion distribution of the promotion service
 func (s *Service) PromoDistribution() error {
 	promos, err := s.repo.GetAllAvailable()
 	if err != nil {
-		fmt.Errorf("Failed to get available promo: %v", err)
+		return fmt.Errorf("Failed to get available promo: %v", err)
 	}
 
 	for _, promo := range promos {
-		p := s.distribute(promo)
-		err = s.repo.Update(p)
+		err = s.modifyPromotion(promo.ID, func(p *Promotion) error {
+			s.distribute(p)
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("Failed to update promo: %v", err)
+			return err
 		}
 	}
 
@@ -116,16 +783,169 @@ func (s *Service) CreatePromotion(promotion *Promotion) (*Promotion, error) {
 	}
 
 	if codeIsExists {
-		return nil, errors.New("Duplicated Promo code")
+		return nil, ErrDuplicatePromoCode
 	}
 
+	if err = s.checkCalendar(promotion.CalendarID); err != nil {
+		return nil, err
+	}
+
+	promotion.refreshStatus(s.clock.Now())
 	err = s.repo.Save(s.distribute(promotion))
+	if err == ErrDuplicatePromoCode {
+		return nil, err
+	}
 	if err != nil {
 		return nil, errors.New("Failed to Save")
 	}
 	return promotion, nil
 }
 
+// GetPromotion represent get promotion by ID of the promotion service
+func (s *Service) GetPromotion(id uuid.UUID) (*Promotion, error) {
+	return s.repo.GetPromotionByID(id)
+}
+
+// GetPromotionByCode represent get promotion by code of the promotion service
+func (s *Service) GetPromotionByCode(code string) (*Promotion, error) {
+	return s.repo.GetPromotionByCode(code)
+}
+
+// UpdatePromotion represent edit promotion of the promotion service, redeemed and held quota is kept
+func (s *Service) UpdatePromotion(id uuid.UUID, req PromoRequest) (*Promotion, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCalendar(req.CalendarID); err != nil {
+		return nil, err
+	}
+
+	edit := func(p *Promotion) error {
+		if p.Code != req.Code {
+			codeIsExists, err := s.repo.ExistsByCode(req.Code)
+			if err != nil {
+				return errors.New("Failed to get existance promo code")
+			}
+
+			if codeIsExists {
+				return ErrDuplicatePromoCode
+			}
+		}
+
+		if err := req.ApplyTo(p); err != nil {
+			return err
+		}
+
+		if p.Distribution != nil {
+			// the daily quota is recalculated, what has been used today stays used
+			s.distribute(p)
+		}
+		return nil
+	}
+
+	if req.Version.Valid {
+		// the edit is made from the promo at Version, a change since then is never overwritten nor retried
+		promo, err := s.readPromotion(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if promo.Version != req.Version.Int64 {
+			return nil, ErrPromoVersionConflict
+		}
+
+		if err = edit(promo); err != nil {
+			return nil, err
+		}
+
+		if err = s.updatePromotion(promo); err != nil {
+			return nil, err
+		}
+		return promo, nil
+	}
+
+	var promo *Promotion
+	err := s.modifyPromotion(id, func(p *Promotion) error {
+		promo = p
+		return edit(p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+// PublishPromotion represent publish a draft promotion of the promotion service
+func (s *Service) PublishPromotion(id uuid.UUID) (*Promotion, error) {
+	return s.changeStatus(id, func(p *Promotion) error {
+		return p.publish(s.clock.Now())
+	})
+}
+
+// PausePromotion represent pause promotion of the promotion service
+func (s *Service) PausePromotion(id uuid.UUID) (*Promotion, error) {
+	return s.changeStatus(id, func(p *Promotion) error {
+		return p.pause()
+	})
+}
+
+// ResumePromotion represent resume a paused promotion of the promotion service
+func (s *Service) ResumePromotion(id uuid.UUID) (*Promotion, error) {
+	return s.changeStatus(id, func(p *Promotion) error {
+		return p.resume(s.clock.Now())
+	})
+}
+
+// ArchivePromotion represent archive promotion of the promotion service, it is the soft delete of a promo
+func (s *Service) ArchivePromotion(id uuid.UUID) (*Promotion, error) {
+	return s.changeStatus(id, func(p *Promotion) error {
+		return p.archive()
+	})
+}
+
+func (s *Service) changeStatus(id uuid.UUID, fn func(*Promotion) error) (*Promotion, error) {
+	var promo *Promotion
+	err := s.modifyPromotion(id, func(p *Promotion) error {
+		if err := fn(p); err != nil {
+			return err
+		}
+		promo = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+// RefreshStatuses represent refresh promotion status of the promotion service, it moves every promo
+// whose end date has passed, whose balance ran out or whose start date has come
+func (s *Service) RefreshStatuses() error {
+	promos, err := s.repo.GetAllByStatus(StatusScheduled, StatusActive, StatusExhausted, StatusExpired, StatusPaused)
+	if err != nil {
+		return fmt.Errorf("Failed to get promo: %v", err)
+	}
+
+	now := s.clock.Now()
+	for _, promo := range promos {
+		if !promo.refreshStatus(now) {
+			continue
+		}
+
+		err = s.modifyPromotion(promo.ID, func(p *Promotion) error {
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // GetAvailablePromo represent get ll available promotion
 func (s *Service) GetAvailablePromo() ([]*Promotion, error) {
 	promotions, err := s.repo.GetAllAvailable()
@@ -135,40 +955,187 @@ func (s *Service) GetAvailablePromo() ([]*Promotion, error) {
 	return promotions, nil
 }
 
-// GetAvailablePromo represent get ll available promotion
-func (s *Service) distribute(p *Promotion) *Promotion {
-	dayRange := int64(1)
-	now := time.Now()
+// CreateCalendar represent create blackout calendar of the promotion service
+func (s *Service) CreateCalendar(req CalendarRequest) (*BlackoutCalendar, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := req.ToCalendar(id)
+	if err = s.repo.SaveCalendar(calendar); err != nil {
+		return nil, fmt.Errorf("Failed to save calendar: %v", err)
+	}
+	return calendar, nil
+}
+
+// ImportCalendar represent create blackout calendar from an iCalendar file of the promotion service,
+// name overrides the calendar name of the file
+func (s *Service) ImportCalendar(name string, r io.Reader) (*BlackoutCalendar, error) {
+	req, err := ParseICalendar(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(name) != "" {
+		req.Name = name
+	}
+	return s.CreateCalendar(*req)
+}
+
+// GetCalendar represent get blackout calendar by ID of the promotion service
+func (s *Service) GetCalendar(id uuid.UUID) (*BlackoutCalendar, error) {
+	return s.repo.GetCalendarByID(id)
+}
 
-	if p.StartDate.Valid && p.EndDate.Valid {
-		duration := p.EndDate.Time.Sub(p.StartDate.Time)
-		if now.After(p.StartDate.Time) && now.Before(p.EndDate.Time) {
-			duration = p.EndDate.Time.Sub(now)
+// GetCalendars represent get all blackout calendars of the promotion service
+func (s *Service) GetCalendars() ([]*BlackoutCalendar, error) {
+	return s.repo.GetAllCalendars()
+}
+
+// UpdateCalendar represent edit blackout calendar of the promotion service, the dates of the request replace
+// the dates of the calendar
+func (s *Service) UpdateCalendar(id uuid.UUID, req CalendarRequest) (*BlackoutCalendar, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	calendar := req.ToCalendar(id)
+	if err := s.repo.UpdateCalendar(calendar); err != nil {
+		return nil, err
+	}
+	return calendar, nil
+}
+
+// DeleteCalendar represent delete blackout calendar of the promotion service, a calendar used by any promo
+// can not be deleted
+func (s *Service) DeleteCalendar(id uuid.UUID) error {
+	if _, err := s.repo.GetCalendarByID(id); err != nil {
+		return err
+	}
+
+	promos, err := s.repo.GetAllByStatus(StatusDraft, StatusScheduled, StatusActive, StatusPaused,
+		StatusExhausted, StatusExpired, StatusArchived)
+	if err != nil {
+		return fmt.Errorf("Failed to get promo: %v", err)
+	}
+
+	for _, promo := range promos {
+		if promo.CalendarID != nil && *promo.CalendarID == id {
+			return ErrCalendarInUse
 		}
-		dayRange = int64(math.Ceil(duration.Hours() / 24))
 	}
 
-	if dayRange < 1 {
-		dayRange = 1
+	return s.repo.DeleteCalendar(id)
+}
+
+// checkCalendar checks the calendar the promo references exists
+func (s *Service) checkCalendar(id *uuid.UUID) error {
+	if id == nil {
+		return nil
+	}
+
+	_, err := s.repo.GetCalendarByID(*id)
+	return err
+}
+
+// loadCalendars loads the blackout calendar of every promo referencing one
+func (s *Service) loadCalendars(promos []*Promotion) error {
+	calendars := map[uuid.UUID]*BlackoutCalendar{}
+	for _, promo := range promos {
+		if promo.CalendarID == nil {
+			promo.calendar = nil
+			continue
+		}
+
+		calendar, ok := calendars[*promo.CalendarID]
+		if !ok {
+			var err error
+			if calendar, err = s.repo.GetCalendarByID(*promo.CalendarID); err != nil {
+				return fmt.Errorf("Failed to get calendar of promo %s: %v", promo.Code, err)
+			}
+			calendars[*promo.CalendarID] = calendar
+		}
+		promo.calendar = calendar
+	}
+
+	return nil
+}
+
+// distribute represent plan today's quota of the promotion in its timezone, today's share of the quota not allocated
+// yet is given by the distribution strategy plus the balance carried from the day before when the strategy carries
+// it. Distributing the same day again plans it anew and keeps what has been used today
+func (s *Service) distribute(p *Promotion) *Promotion {
+	now := s.clock.Now()
+	loc := p.location()
+	strategy, err := lookupDistributionStrategy(p.Strategy)
+	if err != nil {
+		strategy = evenStrategy{}
 	}
 
-	qtyPerDay := int64(1)
-	if p.Balance > dayRange {
-		qtyPerDay = int64(math.Round(float64(p.Balance) / float64(dayRange)))
+	date := now.In(loc).Format(utils.DateLayout)
+	prev := p.Distribution
+	allocated, carried, used, redeem := p.Allocated, int64(0), int64(0), int64(0)
+	var slotStart null.Time
+	var slotBalance int64
+	if prev != nil {
+		if prev.Date == "" || prev.Date == date {
+			// today is distributed again, its allocation is planned anew and what has been used today stays used,
+			// in its slot as well. A distribution of an unknown day counts as today's
+			allocated -= prev.Allocated
+			carried = prev.Carried
+			used = prev.Qty - prev.Balance
+			redeem = prev.Redeem
+			slotStart, slotBalance = prev.SlotStart, prev.SlotBalance
+		} else if strategy.Carry() {
+			carried = prev.Balance
+		}
+	}
+	if allocated < 0 {
+		allocated = 0
 	}
 
-	if qtyPerDay > p.Balance {
-		qtyPerDay = p.Balance
+	// only the quota no day has been given yet is allocated, so the allocations never add up to more than Qty.
+	// Carried quota has been allocated on a day before
+	days := p.distributionDays(now)
+	today := allocateQuota(p.Qty-allocated, strategy.Weights(days))[0]
+	next := s.nextDistribution(p, now)
+	if p.StartDate.Valid && now.Before(p.StartDate.Time) {
+		// no quota is given before the promo starts, the first day is distributed when it starts
+		today = 0
+		next = p.StartDate.Time.UTC()
 	}
 
-	pd := &PromoDistribution{
-		PromoID: p.ID,
-		Qty:     qtyPerDay,
-		Redeem:  0,
-		Balance: qtyPerDay,
+	if carried > p.Balance {
+		carried = p.Balance
+	}
+	if today > p.Balance-carried {
+		today = p.Balance - carried
+	}
+	qty := today + carried
+	balance := qty - used
+	if balance < 0 {
+		balance = 0
 	}
 
-	p.Distribution = pd
+	p.Allocated = allocated + today
+	p.Distribution = &PromoDistribution{
+		PromoID:       p.ID,
+		Date:          date,
+		Qty:           qty,
+		Redeem:        redeem,
+		Balance:       balance,
+		Carried:       carried,
+		Allocated:     today,
+		DistributedAt: null.TimeFrom(now.UTC()),
+		NextRunAt:     null.TimeFrom(next),
+		SlotStart:     slotStart,
+		SlotBalance:   slotBalance,
+	}
 
 	return p
 }
@@ -0,0 +1,183 @@
+package promotion
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	SlotHour        = "hour"
+	SlotQuarterHour = "15m"
+)
+
+var ErrPromoSlotExhausted = errors.New("Promo slot quota is exhausted")
+
+// maxSlotLookahead bounds how far the next slot is looked for
+const maxSlotLookahead = 8 * 24 * time.Hour
+
+var slotSizes = map[string]time.Duration{
+	SlotHour:        time.Hour,
+	SlotQuarterHour: 15 * time.Minute,
+}
+
+// SlotConfig represent the quota slots of a flash sale, every slot of Size has its own quota of Qty out of the
+// daily quota. Windows limits the time of day the slots open, every slot of the day opens when it is empty
+type SlotConfig struct {
+	Size    string     `json:"size"`
+	Qty     int64      `json:"qty"`
+	Windows WindowList `json:"windows,omitempty"`
+}
+
+// Value implements the driver Valuer interface
+func (c *SlotConfig) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the Scanner interface
+func (c *SlotConfig) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	}
+
+	return fmt.Errorf("can not scan %T into SlotConfig", value)
+}
+
+// clone returns a copy of the config which shares no pointers with c
+func (c *SlotConfig) clone() *SlotConfig {
+	if c == nil {
+		return nil
+	}
+
+	res := *c
+	res.Windows = c.Windows.clone()
+	return &res
+}
+
+// validate checks the size, the quota and the windows of the slots
+func (c *SlotConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if _, ok := slotSizes[c.Size]; !ok {
+		return fmt.Errorf("Slot Size '%s' is unknown, use '%s' or '%s'", c.Size, SlotHour, SlotQuarterHour)
+	}
+
+	if c.Qty <= 0 {
+		return errors.New("Slot Quota must be greater than 0")
+	}
+
+	return c.Windows.validate()
+}
+
+// start returns when the slot t falls in starts, slots are aligned to the wall clock of t
+func (c *SlotConfig) start(t time.Time) time.Time {
+	step := int(slotSizes[c.Size] / time.Minute)
+	minute := t.Minute() - t.Minute()%step
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), minute, 0, 0, t.Location())
+}
+
+// open reports whether the slot starting at t is in one of the windows
+func (c *SlotConfig) open(t time.Time) bool {
+	if len(c.Windows) == 0 {
+		return true
+	}
+
+	for _, w := range c.Windows {
+		if ok, _ := w.contains(t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// slotStart returns the slot the booking time t falls in, in the promo timezone
+func (p *Promotion) slotStart(t time.Time) time.Time {
+	return p.Slots.start(t.In(p.location()))
+}
+
+// slotBalance returns the quota left in the slot of t, a slot which has not been taken from yet has its whole
+// quota. It is zero when the slot is not open
+func (p *Promotion) slotBalance(t time.Time) int64 {
+	start := p.slotStart(t)
+	if !p.Slots.open(start) {
+		return 0
+	}
+
+	if p.Distribution != nil && p.Distribution.SlotStart.Valid && p.Distribution.SlotStart.Time.Equal(start) {
+		return p.Distribution.SlotBalance
+	}
+	return p.Slots.Qty
+}
+
+// nextSlot returns when the first open slot after the slot of t starts, it is not valid when no slot opens
+// before the promo ends
+func (p *Promotion) nextSlot(t time.Time) null.Time {
+	size := slotSizes[p.Slots.Size]
+	start := p.slotStart(t)
+	for next := start.Add(size); next.Sub(start) <= maxSlotLookahead; next = next.Add(size) {
+		if p.EndDate.Valid && next.After(p.EndDate.Time) {
+			break
+		}
+		if p.Slots.open(next) {
+			return null.TimeFrom(next.UTC())
+		}
+	}
+	return null.Time{}
+}
+
+// takeSlot takes one unit from the slot of t
+func (p *Promotion) takeSlot(t time.Time) {
+	if p.Slots == nil || p.Distribution == nil {
+		return
+	}
+
+	balance := p.slotBalance(t)
+	p.Distribution.SlotStart = null.TimeFrom(p.slotStart(t).UTC())
+	p.Distribution.SlotBalance = balance - 1
+}
+
+// releaseSlot returns a unit taken at t to its slot, once the slot is over the unit is not given back
+func (p *Promotion) releaseSlot(t time.Time) {
+	if p.Slots == nil || p.Distribution == nil || !p.Distribution.SlotStart.Valid {
+		return
+	}
+
+	if p.Distribution.SlotStart.Time.Equal(p.slotStart(t)) {
+		p.Distribution.SlotBalance++
+	}
+}
+
+// slotRule checks the slot of the booking time has quota left, the failure tells when the next slot opens
+func (p *Promotion) slotRule(t time.Time) error {
+	if p.Slots == nil || p.slotBalance(t) > 0 {
+		return nil
+	}
+
+	params := map[string]interface{}{
+		"slot": p.slotStart(t).UTC(),
+		"size": p.Slots.Size,
+	}
+	message := "Promo slot quota is exhausted"
+	if next := p.nextSlot(t); next.Valid {
+		params["nextSlotAt"] = next.Time
+		message = fmt.Sprintf("%s, the next slot opens at %s", message, next.Time.In(p.location()).Format(time.RFC3339))
+	}
+	return newFailure(FailureSlotExhausted, message, params)
+}
@@ -0,0 +1,610 @@
+package promotion
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration represent a versioned schema change, Up holds the statements of every dialect
+type migration struct {
+	Version int
+	Up      map[Dialect][]string
+}
+
+var migrations = []migration{
+	{
+		Version: 1,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE promotions (
+					id TEXT PRIMARY KEY,
+					title TEXT NOT NULL,
+					code TEXT NOT NULL,
+					start_date TIMESTAMP NULL,
+					end_date TIMESTAMP NULL,
+					percentage INTEGER NULL,
+					amount REAL NULL,
+					qty INTEGER NOT NULL DEFAULT 0,
+					reedem INTEGER NOT NULL DEFAULT 0,
+					balance INTEGER NOT NULL DEFAULT 0,
+					status INTEGER NOT NULL DEFAULT 0,
+					min_night INTEGER NULL,
+					min_room INTEGER NULL,
+					checkin_day TEXT NULL,
+					booking_day TEXT NULL,
+					booking_hour_start INTEGER NULL,
+					booking_hour_end INTEGER NULL
+				)`,
+				`CREATE UNIQUE INDEX promotions_code_idx ON promotions (code)`,
+				`CREATE TABLE promo_distributions (
+					promo_id TEXT PRIMARY KEY REFERENCES promotions (id),
+					qty INTEGER NOT NULL DEFAULT 0,
+					reedem INTEGER NOT NULL DEFAULT 0,
+					balance INTEGER NOT NULL DEFAULT 0
+				)`,
+				`CREATE TABLE promo_redemptions (
+					id TEXT PRIMARY KEY,
+					promo_id TEXT NOT NULL REFERENCES promotions (id),
+					code TEXT NOT NULL,
+					booking_ref TEXT NOT NULL,
+					original_price REAL NOT NULL,
+					promo_price REAL NOT NULL,
+					final_price REAL NOT NULL,
+					created_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE INDEX promo_redemptions_booking_ref_idx ON promo_redemptions (booking_ref)`,
+				`CREATE TABLE promo_holds (
+					id TEXT PRIMARY KEY,
+					promo_id TEXT NOT NULL REFERENCES promotions (id),
+					code TEXT NOT NULL,
+					booking_ref TEXT NOT NULL,
+					status TEXT NOT NULL,
+					original_price REAL NOT NULL,
+					promo_price REAL NOT NULL,
+					final_price REAL NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					expires_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE INDEX promo_holds_booking_ref_idx ON promo_holds (booking_ref)`,
+				`CREATE INDEX promo_holds_status_expires_at_idx ON promo_holds (status, expires_at)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE promotions (
+					id UUID PRIMARY KEY,
+					title TEXT NOT NULL,
+					code TEXT NOT NULL,
+					start_date TIMESTAMPTZ NULL,
+					end_date TIMESTAMPTZ NULL,
+					percentage BIGINT NULL,
+					amount DOUBLE PRECISION NULL,
+					qty BIGINT NOT NULL DEFAULT 0,
+					reedem BIGINT NOT NULL DEFAULT 0,
+					balance BIGINT NOT NULL DEFAULT 0,
+					status BIGINT NOT NULL DEFAULT 0,
+					min_night BIGINT NULL,
+					min_room BIGINT NULL,
+					checkin_day TEXT NULL,
+					booking_day TEXT NULL,
+					booking_hour_start BIGINT NULL,
+					booking_hour_end BIGINT NULL
+				)`,
+				`CREATE UNIQUE INDEX promotions_code_idx ON promotions (code)`,
+				`CREATE TABLE promo_distributions (
+					promo_id UUID PRIMARY KEY REFERENCES promotions (id),
+					qty BIGINT NOT NULL DEFAULT 0,
+					reedem BIGINT NOT NULL DEFAULT 0,
+					balance BIGINT NOT NULL DEFAULT 0
+				)`,
+				`CREATE TABLE promo_redemptions (
+					id UUID PRIMARY KEY,
+					promo_id UUID NOT NULL REFERENCES promotions (id),
+					code TEXT NOT NULL,
+					booking_ref TEXT NOT NULL,
+					original_price DOUBLE PRECISION NOT NULL,
+					promo_price DOUBLE PRECISION NOT NULL,
+					final_price DOUBLE PRECISION NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX promo_redemptions_booking_ref_idx ON promo_redemptions (booking_ref)`,
+				`CREATE TABLE promo_holds (
+					id UUID PRIMARY KEY,
+					promo_id UUID NOT NULL REFERENCES promotions (id),
+					code TEXT NOT NULL,
+					booking_ref TEXT NOT NULL,
+					status TEXT NOT NULL,
+					original_price DOUBLE PRECISION NOT NULL,
+					promo_price DOUBLE PRECISION NOT NULL,
+					final_price DOUBLE PRECISION NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					expires_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX promo_holds_booking_ref_idx ON promo_holds (booking_ref)`,
+				`CREATE INDEX promo_holds_status_expires_at_idx ON promo_holds (status, expires_at)`,
+			},
+		},
+	},
+	{
+		Version: 2,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN version BIGINT NOT NULL DEFAULT 0`,
+			},
+		},
+	},
+	{
+		// status moves from 1 (available) / 0 (deleted) to the lifecycle states
+		Version: 3,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN lifecycle_status TEXT NOT NULL DEFAULT 'draft'`,
+				`UPDATE promotions SET lifecycle_status = CASE status WHEN 1 THEN 'active' ELSE 'archived' END`,
+				`ALTER TABLE promotions DROP COLUMN status`,
+				`ALTER TABLE promotions RENAME COLUMN lifecycle_status TO status`,
+				`CREATE INDEX promotions_status_idx ON promotions (status)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ALTER COLUMN status DROP DEFAULT`,
+				`ALTER TABLE promotions ALTER COLUMN status TYPE TEXT
+					USING (CASE status WHEN 1 THEN 'active' ELSE 'archived' END)`,
+				`ALTER TABLE promotions ALTER COLUMN status SET DEFAULT 'draft'`,
+				`CREATE INDEX promotions_status_idx ON promotions (status)`,
+			},
+		},
+	},
+	{
+		Version: 4,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN stackable BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE promotions ADD COLUMN exclusive_group TEXT NULL`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN stackable BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE promotions ADD COLUMN exclusive_group TEXT NULL`,
+			},
+		},
+	},
+	{
+		Version: 5,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN public BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE promotions ADD COLUMN auto_apply BOOLEAN NOT NULL DEFAULT FALSE`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN public BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE promotions ADD COLUMN auto_apply BOOLEAN NOT NULL DEFAULT FALSE`,
+			},
+		},
+	},
+	{
+		// money columns move from floating point to exact decimals and every amount names its currency
+		Version: 6,
+		Up: map[Dialect][]string{
+			DialectSQLite: concat(
+				sqliteDecimalColumn("promotions", "amount", "NULL"),
+				sqliteDecimalColumn("promo_redemptions", "original_price", "NOT NULL DEFAULT '0'"),
+				sqliteDecimalColumn("promo_redemptions", "promo_price", "NOT NULL DEFAULT '0'"),
+				sqliteDecimalColumn("promo_redemptions", "final_price", "NOT NULL DEFAULT '0'"),
+				sqliteDecimalColumn("promo_holds", "original_price", "NOT NULL DEFAULT '0'"),
+				sqliteDecimalColumn("promo_holds", "promo_price", "NOT NULL DEFAULT '0'"),
+				sqliteDecimalColumn("promo_holds", "final_price", "NOT NULL DEFAULT '0'"),
+				[]string{
+					`ALTER TABLE promotions ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR'`,
+					`ALTER TABLE promo_redemptions ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR'`,
+					`ALTER TABLE promo_holds ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR'`,
+				},
+			),
+			DialectPostgres: {
+				`ALTER TABLE promotions ALTER COLUMN amount TYPE NUMERIC(20, 2)`,
+				`ALTER TABLE promo_redemptions ALTER COLUMN original_price TYPE NUMERIC(20, 2),
+					ALTER COLUMN promo_price TYPE NUMERIC(20, 2),
+					ALTER COLUMN final_price TYPE NUMERIC(20, 2)`,
+				`ALTER TABLE promo_holds ALTER COLUMN original_price TYPE NUMERIC(20, 2),
+					ALTER COLUMN promo_price TYPE NUMERIC(20, 2),
+					ALTER COLUMN final_price TYPE NUMERIC(20, 2)`,
+				`ALTER TABLE promotions ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR'`,
+				`ALTER TABLE promo_redemptions ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR'`,
+				`ALTER TABLE promo_holds ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR'`,
+			},
+		},
+	},
+	{
+		Version: 7,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN max_discount TEXT NULL`,
+				`ALTER TABLE promotions ADD COLUMN min_price TEXT NULL`,
+				`ALTER TABLE promotions ADD COLUMN min_spend TEXT NULL`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN max_discount NUMERIC(20, 2) NULL`,
+				`ALTER TABLE promotions ADD COLUMN min_price NUMERIC(20, 2) NULL`,
+				`ALTER TABLE promotions ADD COLUMN min_spend NUMERIC(20, 2) NULL`,
+			},
+		},
+	},
+	{
+		Version: 8,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN scope TEXT NOT NULL DEFAULT 'room'`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN scope TEXT NOT NULL DEFAULT 'room'`,
+			},
+		},
+	},
+	{
+		Version: 9,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN stay_start TIMESTAMP NULL`,
+				`ALTER TABLE promotions ADD COLUMN stay_end TIMESTAMP NULL`,
+				`ALTER TABLE promotions ADD COLUMN stay_days TEXT NULL`,
+				`ALTER TABLE promotions ADD COLUMN blackout_dates TEXT NULL`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN stay_start TIMESTAMPTZ NULL`,
+				`ALTER TABLE promotions ADD COLUMN stay_end TIMESTAMPTZ NULL`,
+				`ALTER TABLE promotions ADD COLUMN stay_days TEXT NULL`,
+				`ALTER TABLE promotions ADD COLUMN blackout_dates TEXT NULL`,
+			},
+		},
+	},
+	{
+		Version: 10,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE blackout_calendars (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL
+				)`,
+				`CREATE TABLE blackout_calendar_dates (
+					calendar_id TEXT NOT NULL REFERENCES blackout_calendars (id),
+					date TEXT NOT NULL,
+					name TEXT NOT NULL,
+					PRIMARY KEY (calendar_id, date)
+				)`,
+				`ALTER TABLE promotions ADD COLUMN calendar_id TEXT NULL REFERENCES blackout_calendars (id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE blackout_calendars (
+					id UUID PRIMARY KEY,
+					name TEXT NOT NULL
+				)`,
+				`CREATE TABLE blackout_calendar_dates (
+					calendar_id UUID NOT NULL REFERENCES blackout_calendars (id),
+					date TEXT NOT NULL,
+					name TEXT NOT NULL,
+					PRIMARY KEY (calendar_id, date)
+				)`,
+				`ALTER TABLE promotions ADD COLUMN calendar_id UUID NULL REFERENCES blackout_calendars (id)`,
+			},
+		},
+	},
+	{
+		Version: 11,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN timezone TEXT NOT NULL DEFAULT ''`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN timezone TEXT NOT NULL DEFAULT ''`,
+			},
+		},
+	},
+	{
+		// the whole booking hours become a booking window, the end hour was inclusive
+		Version: 12,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN booking_windows TEXT NULL`,
+				`UPDATE promotions SET booking_windows = '[{"start":"' || printf('%02d:00', booking_hour_start) ||
+					'","end":"' || printf('%02d:00', (booking_hour_end + 1) % 24) || '"}]'
+					WHERE booking_hour_start IS NOT NULL AND booking_hour_end IS NOT NULL`,
+				`ALTER TABLE promotions DROP COLUMN booking_hour_start`,
+				`ALTER TABLE promotions DROP COLUMN booking_hour_end`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN booking_windows TEXT NULL`,
+				`UPDATE promotions SET booking_windows = '[{"start":"' || lpad(booking_hour_start::text, 2, '0') ||
+					':00","end":"' || lpad(((booking_hour_end + 1) % 24)::text, 2, '0') || ':00"}]'
+					WHERE booking_hour_start IS NOT NULL AND booking_hour_end IS NOT NULL`,
+				`ALTER TABLE promotions DROP COLUMN booking_hour_start`,
+				`ALTER TABLE promotions DROP COLUMN booking_hour_end`,
+			},
+		},
+	},
+	{
+		// min night, min room, checkin and booking days and booking windows move into the rules of the promo
+		Version: 13,
+		Up: map[Dialect][]string{
+			DialectSQLite: concat(
+				[]string{
+					`ALTER TABLE promotions ADD COLUMN rules TEXT NULL`,
+					`UPDATE promotions SET rules = (SELECT json_group_array(json(r.rule)) FROM (
+						SELECT json_object('type', 'min_night', 'params', json_object('min', min_night)) AS rule WHERE min_night IS NOT NULL
+						UNION ALL SELECT json_object('type', 'min_room', 'params', json_object('min', min_room)) WHERE min_room IS NOT NULL
+						UNION ALL SELECT json_object('type', 'checkin_day', 'params', json_object('days', checkin_day)) WHERE checkin_day IS NOT NULL
+						UNION ALL SELECT json_object('type', 'booking_day', 'params', json_object('days', booking_day)) WHERE booking_day IS NOT NULL
+						UNION ALL SELECT json_object('type', 'booking_window', 'params', json_object('windows', json(booking_windows)))
+							WHERE booking_windows IS NOT NULL AND booking_windows <> 'null'
+					) r)`,
+					`UPDATE promotions SET rules = NULL WHERE rules = '[]'`,
+				},
+				dropColumns("promotions", "min_night", "min_room", "checkin_day", "booking_day", "booking_windows"),
+			),
+			DialectPostgres: concat(
+				[]string{
+					`ALTER TABLE promotions ADD COLUMN rules TEXT NULL`,
+					`UPDATE promotions SET rules = (SELECT json_agg(r.rule)::text FROM (
+						SELECT json_build_object('type', 'min_night', 'params', json_build_object('min', min_night)) AS rule WHERE min_night IS NOT NULL
+						UNION ALL SELECT json_build_object('type', 'min_room', 'params', json_build_object('min', min_room)) WHERE min_room IS NOT NULL
+						UNION ALL SELECT json_build_object('type', 'checkin_day', 'params', json_build_object('days', checkin_day)) WHERE checkin_day IS NOT NULL
+						UNION ALL SELECT json_build_object('type', 'booking_day', 'params', json_build_object('days', booking_day)) WHERE booking_day IS NOT NULL
+						UNION ALL SELECT json_build_object('type', 'booking_window', 'params', json_build_object('windows', booking_windows::json))
+							WHERE booking_windows IS NOT NULL AND booking_windows <> 'null'
+					) r)`,
+				},
+				dropColumns("promotions", "min_night", "min_room", "checkin_day", "booking_day", "booking_windows"),
+			),
+		},
+	},
+	{
+		// the scheduler keeps when every promo is distributed next, the lock and the last run of its job
+		Version: 14,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promo_distributions ADD COLUMN distributed_at TIMESTAMP NULL`,
+				`ALTER TABLE promo_distributions ADD COLUMN next_run_at TIMESTAMP NULL`,
+				`CREATE TABLE job_locks (
+					name TEXT PRIMARY KEY,
+					owner TEXT NOT NULL,
+					expires_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE TABLE job_runs (
+					job TEXT PRIMARY KEY,
+					instance TEXT NOT NULL,
+					started_at TIMESTAMP NOT NULL,
+					finished_at TIMESTAMP NOT NULL,
+					distributed INTEGER NOT NULL DEFAULT 0,
+					error TEXT NOT NULL DEFAULT ''
+				)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promo_distributions ADD COLUMN distributed_at TIMESTAMPTZ NULL`,
+				`ALTER TABLE promo_distributions ADD COLUMN next_run_at TIMESTAMPTZ NULL`,
+				`CREATE TABLE job_locks (
+					name TEXT PRIMARY KEY,
+					owner TEXT NOT NULL,
+					expires_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE TABLE job_runs (
+					job TEXT PRIMARY KEY,
+					instance TEXT NOT NULL,
+					started_at TIMESTAMPTZ NOT NULL,
+					finished_at TIMESTAMPTZ NOT NULL,
+					distributed BIGINT NOT NULL DEFAULT 0,
+					error TEXT NOT NULL DEFAULT ''
+				)`,
+			},
+		},
+	},
+	{
+		// the quota a distribution allocated so far is what has been used and what is left for today
+		Version: 15,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN distribution_strategy TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE promo_distributions ADD COLUMN carried INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE promo_distributions ADD COLUMN allocated INTEGER NOT NULL DEFAULT 0`,
+				`UPDATE promo_distributions SET allocated = balance +
+					(SELECT p.qty - p.balance FROM promotions p WHERE p.id = promo_distributions.promo_id)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN distribution_strategy TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE promo_distributions ADD COLUMN carried BIGINT NOT NULL DEFAULT 0`,
+				`ALTER TABLE promo_distributions ADD COLUMN allocated BIGINT NOT NULL DEFAULT 0`,
+				`UPDATE promo_distributions d SET allocated = d.balance + p.qty - p.balance
+					FROM promotions p WHERE p.id = d.promo_id`,
+			},
+		},
+	},
+	{
+		// every day of a promo is kept as its distribution ledger, the total allocated moves to the promo. A
+		// distribution of an unknown day is dated today, the day of the older ones is taken in UTC
+		Version: 16,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE promo_distribution_days (
+					promo_id TEXT NOT NULL REFERENCES promotions (id),
+					date TEXT NOT NULL,
+					qty INTEGER NOT NULL DEFAULT 0,
+					reedem INTEGER NOT NULL DEFAULT 0,
+					balance INTEGER NOT NULL DEFAULT 0,
+					carried INTEGER NOT NULL DEFAULT 0,
+					allocated INTEGER NOT NULL DEFAULT 0,
+					distributed_at TIMESTAMP NULL,
+					next_run_at TIMESTAMP NULL,
+					PRIMARY KEY (promo_id, date)
+				)`,
+				`ALTER TABLE promotions ADD COLUMN allocated INTEGER NOT NULL DEFAULT 0`,
+				`UPDATE promotions SET allocated = COALESCE(
+					(SELECT d.allocated FROM promo_distributions d WHERE d.promo_id = promotions.id), 0)`,
+				`INSERT INTO promo_distribution_days (promo_id, date, qty, reedem, balance, carried, allocated, distributed_at, next_run_at)
+					SELECT promo_id, COALESCE(date(distributed_at), date('now')), qty, reedem, balance, carried, qty - carried,
+						distributed_at, next_run_at
+					FROM promo_distributions`,
+				`DROP TABLE promo_distributions`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE promo_distribution_days (
+					promo_id UUID NOT NULL REFERENCES promotions (id),
+					date TEXT NOT NULL,
+					qty BIGINT NOT NULL DEFAULT 0,
+					reedem BIGINT NOT NULL DEFAULT 0,
+					balance BIGINT NOT NULL DEFAULT 0,
+					carried BIGINT NOT NULL DEFAULT 0,
+					allocated BIGINT NOT NULL DEFAULT 0,
+					distributed_at TIMESTAMPTZ NULL,
+					next_run_at TIMESTAMPTZ NULL,
+					PRIMARY KEY (promo_id, date)
+				)`,
+				`ALTER TABLE promotions ADD COLUMN allocated BIGINT NOT NULL DEFAULT 0`,
+				`UPDATE promotions p SET allocated = d.allocated FROM promo_distributions d WHERE d.promo_id = p.id`,
+				`INSERT INTO promo_distribution_days (promo_id, date, qty, reedem, balance, carried, allocated, distributed_at, next_run_at)
+					SELECT promo_id, to_char(COALESCE(distributed_at, now()) AT TIME ZONE 'UTC', 'YYYY-MM-DD'),
+						qty, reedem, balance, carried, qty - carried, distributed_at, next_run_at
+					FROM promo_distributions`,
+				`DROP TABLE promo_distributions`,
+			},
+		},
+	},
+	{
+		Version: 17,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN slots TEXT NULL`,
+				`ALTER TABLE promo_distribution_days ADD COLUMN slot_start TIMESTAMP NULL`,
+				`ALTER TABLE promo_distribution_days ADD COLUMN slot_balance INTEGER NOT NULL DEFAULT 0`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN slots TEXT NULL`,
+				`ALTER TABLE promo_distribution_days ADD COLUMN slot_start TIMESTAMPTZ NULL`,
+				`ALTER TABLE promo_distribution_days ADD COLUMN slot_balance BIGINT NOT NULL DEFAULT 0`,
+			},
+		},
+	},
+	{
+		Version: 18,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE promotions ADD COLUMN guest_limit INTEGER NULL`,
+				`ALTER TABLE promotions ADD COLUMN guest_daily_limit INTEGER NULL`,
+				`ALTER TABLE promotions ADD COLUMN first_booking BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE promo_redemptions ADD COLUMN guest TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE promo_holds ADD COLUMN guest TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX promo_redemptions_guest_idx ON promo_redemptions (guest)`,
+				`CREATE INDEX promo_holds_guest_idx ON promo_holds (guest)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE promotions ADD COLUMN guest_limit BIGINT NULL`,
+				`ALTER TABLE promotions ADD COLUMN guest_daily_limit BIGINT NULL`,
+				`ALTER TABLE promotions ADD COLUMN first_booking BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE promo_redemptions ADD COLUMN guest TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE promo_holds ADD COLUMN guest TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX promo_redemptions_guest_idx ON promo_redemptions (guest)`,
+				`CREATE INDEX promo_holds_guest_idx ON promo_holds (guest)`,
+			},
+		},
+	},
+	{
+		// a booking redeems a promo once, also when the redemptions are made by different instances
+		Version: 19,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE UNIQUE INDEX promo_redemptions_booking_ref_promo_id_idx ON promo_redemptions (booking_ref, promo_id)`,
+			},
+			DialectPostgres: {
+				`CREATE UNIQUE INDEX promo_redemptions_booking_ref_promo_id_idx ON promo_redemptions (booking_ref, promo_id)`,
+			},
+		},
+	},
+	{
+		// every booking of a guest bumps the guest version, the limits per guest are checked against it
+		Version: 20,
+		Up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE promo_guests (
+					guest TEXT PRIMARY KEY,
+					version INTEGER NOT NULL
+				)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE promo_guests (
+					guest TEXT PRIMARY KEY,
+					version BIGINT NOT NULL
+				)`,
+			},
+		},
+	},
+}
+
+// dropColumns drops every column of a table
+func dropColumns(table string, columns ...string) []string {
+	res := []string{}
+	for _, column := range columns {
+		res = append(res, fmt.Sprintf(`ALTER TABLE %s DROP COLUMN %s`, table, column))
+	}
+	return res
+}
+
+// sqliteDecimalColumn turns a REAL column into TEXT so the decimal is kept exactly, sqlite can not alter a column type
+func sqliteDecimalColumn(table, column, constraint string) []string {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s_decimal TEXT %s`, table, column, constraint),
+		fmt.Sprintf(`UPDATE %s SET %s_decimal = CAST(%s AS TEXT)`, table, column, column),
+		fmt.Sprintf(`ALTER TABLE %s DROP COLUMN %s`, table, column),
+		fmt.Sprintf(`ALTER TABLE %s RENAME COLUMN %s_decimal TO %s`, table, column, column),
+	}
+}
+
+func concat(lists ...[]string) []string {
+	res := []string{}
+	for _, list := range lists {
+		res = append(res, list...)
+	}
+	return res
+}
+
+// Migrate applies every migration which has not been applied to the database yet
+func Migrate(db *sql.DB, dialect Dialect) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("Failed to create schema_migrations: %v", err)
+	}
+
+	for _, m := range migrations {
+		var applied int
+		row := db.QueryRow(dialect.rebind(`SELECT COUNT(1) FROM schema_migrations WHERE version = ?`), m.Version)
+		if err = row.Scan(&applied); err != nil {
+			return fmt.Errorf("Failed to read schema_migrations: %v", err)
+		}
+
+		if applied > 0 {
+			continue
+		}
+
+		statements, ok := m.Up[dialect]
+		if !ok {
+			return fmt.Errorf("Migration %d has no %s statements", m.Version, dialect)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, stmt := range statements {
+			if _, err = tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("Migration %d failed: %v", m.Version, err)
+			}
+		}
+
+		if _, err = tx.Exec(dialect.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), m.Version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Migration %d failed: %v", m.Version, err)
+		}
+
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("Migration %d failed: %v", m.Version, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,723 @@
+package promotion
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Dialect represent SQL flavour of the database behind SQLRepository
+type Dialect string
+
+const (
+	DialectSQLite   Dialect = "sqlite3"
+	DialectPostgres Dialect = "postgres"
+)
+
+// rebind converts ? placeholders into the placeholders of the dialect
+func (d Dialect) rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+const promoColumns = `p.id, p.title, p.code, p.start_date, p.end_date, p.percentage, p.amount, p.currency,
+	p.max_discount, p.min_price, p.min_spend, p.scope, p.timezone, p.distribution_strategy, p.qty, p.allocated, p.reedem, p.balance, p.status,
+	p.stay_start, p.stay_end, p.stay_days, p.blackout_dates, p.calendar_id,
+	p.rules, p.slots, p.guest_limit, p.guest_daily_limit, p.first_booking, p.stackable, p.exclusive_group, p.public, p.auto_apply, p.version,
+	d.promo_id, d.date, d.qty, d.reedem, d.balance, d.carried, d.allocated, d.distributed_at, d.next_run_at, d.slot_start, d.slot_balance`
+
+// promoSelect joins the promo with the day it was distributed last
+const promoSelect = `SELECT ` + promoColumns + ` FROM promotions p
+	LEFT JOIN promo_distribution_days d ON d.promo_id = p.id
+		AND d.date = (SELECT MAX(l.date) FROM promo_distribution_days l WHERE l.promo_id = p.id)`
+
+const distributionColumns = `promo_id, date, qty, reedem, balance, carried, allocated, distributed_at, next_run_at, slot_start, slot_balance`
+
+const redemptionColumns = `id, promo_id, code, booking_ref, guest, currency, original_price, promo_price, final_price, created_at`
+
+const holdColumns = `id, promo_id, code, booking_ref, guest, status, currency, original_price, promo_price, final_price, created_at, expires_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer represent the database or a transaction of it
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// SQLRepository represent database/sql repository of promo
+type SQLRepository struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func (r *SQLRepository) scanPromotion(row scanner) (*Promotion, error) {
+	p := &Promotion{}
+	var distID uuid.NullUUID
+	var distDate sql.NullString
+	var distQty, distRedeem, distBalance, distCarried, distAllocated, slotBalance sql.NullInt64
+	var distributedAt, nextRunAt, slotStart null.Time
+	err := row.Scan(
+		&p.ID, &p.Title, &p.Code, &p.StartDate, &p.EndDate, &p.Percentage, &p.Amount, &p.Currency,
+		&p.MaxDiscount, &p.MinPrice, &p.MinSpend, &p.Scope, &p.Timezone, &p.Strategy, &p.Qty, &p.Allocated, &p.Redeem, &p.Balance, &p.Status,
+		&p.StayStart, &p.StayEnd, &p.StayDays, &p.BlackoutDates, &p.CalendarID,
+		&p.Rules, &p.Slots, &p.GuestLimit, &p.DailyLimit, &p.FirstBooking, &p.Stackable, &p.ExclusiveGroup, &p.Public, &p.AutoApply, &p.Version,
+		&distID, &distDate, &distQty, &distRedeem, &distBalance, &distCarried, &distAllocated, &distributedAt, &nextRunAt, &slotStart, &slotBalance,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if distID.Valid {
+		p.Distribution = &PromoDistribution{
+			PromoID:       distID.UUID,
+			Date:          distDate.String,
+			Qty:           distQty.Int64,
+			Redeem:        distRedeem.Int64,
+			Balance:       distBalance.Int64,
+			Carried:       distCarried.Int64,
+			Allocated:     distAllocated.Int64,
+			DistributedAt: distributedAt,
+			NextRunAt:     nextRunAt,
+			SlotStart:     slotStart,
+			SlotBalance:   slotBalance.Int64,
+		}
+	}
+
+	return p, nil
+}
+
+func (r *SQLRepository) queryPromotions(query string, args ...interface{}) ([]*Promotion, error) {
+	rows, err := r.db.Query(r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*Promotion{}
+	for rows.Next() {
+		p, err := r.scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+func (r *SQLRepository) getPromotion(query string, args ...interface{}) (*Promotion, error) {
+	p, err := r.scanPromotion(r.db.QueryRow(r.dialect.rebind(query), args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrPromoNotFound
+	}
+	return p, err
+}
+
+// GetPromotionByCode represent get promotion by code
+func (r *SQLRepository) GetPromotionByCode(code string) (*Promotion, error) {
+	return r.getPromotion(promoSelect+` WHERE p.code = ?`, code)
+}
+
+// GetPromotionByID represent get promotion by ID
+func (r *SQLRepository) GetPromotionByID(id uuid.UUID) (*Promotion, error) {
+	return r.getPromotion(promoSelect+` WHERE p.id = ?`, id)
+}
+
+// ExistsByCode represent get existance promotion code
+func (r *SQLRepository) ExistsByCode(code string) (bool, error) {
+	var count int
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT COUNT(1) FROM promotions WHERE code = ?`), code).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetAllAvailable represent get all active promotion
+func (r *SQLRepository) GetAllAvailable() ([]*Promotion, error) {
+	return r.GetAllByStatus(StatusActive)
+}
+
+// GetAllByStatus represent get all promotion in one of the statuses
+func (r *SQLRepository) GetAllByStatus(statuses ...PromoStatus) ([]*Promotion, error) {
+	if len(statuses) == 0 {
+		return []*Promotion{}, nil
+	}
+
+	args := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		args[i] = status
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ")
+	return r.queryPromotions(promoSelect+` WHERE p.status IN (`+placeholders+`) ORDER BY p.code`, args...)
+}
+
+// Save represent save promotion repository
+func (r *SQLRepository) Save(p *Promotion) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(r.dialect.rebind(`INSERT INTO promotions (
+		id, title, code, start_date, end_date, percentage, amount, currency, max_discount, min_price, min_spend, scope, timezone, distribution_strategy, qty, allocated, reedem, balance, status,
+		stay_start, stay_end, stay_days, blackout_dates, calendar_id,
+		rules, slots, guest_limit, guest_daily_limit, first_booking, stackable, exclusive_group, public, auto_apply, version
+	) VALUES (`+placeholders(34)+`)`),
+		p.ID, p.Title, p.Code, utcTime(p.StartDate.Ptr()), utcTime(p.EndDate.Ptr()), p.Percentage, p.Amount, p.currency(),
+		p.MaxDiscount, p.MinPrice, p.MinSpend, p.scope(), p.Timezone, p.Strategy,
+		p.Qty, p.Allocated, p.Redeem, p.Balance, p.Status,
+		utcTime(p.StayStart.Ptr()), utcTime(p.StayEnd.Ptr()), p.StayDays, p.BlackoutDates, p.CalendarID,
+		p.Rules, p.Slots, p.GuestLimit, p.DailyLimit, p.FirstBooking, p.Stackable, p.ExclusiveGroup, p.Public, p.AutoApply, p.Version,
+	)
+	if err == nil {
+		err = r.saveDistribution(tx, p.Distribution)
+	}
+	if err != nil {
+		tx.Rollback()
+		return r.promoCodeError(p, err)
+	}
+
+	return tx.Commit()
+}
+
+// promoCodeError turns the error of saving p into ErrDuplicatePromoCode when another promo has its code, the
+// transaction of the insert has to be rolled back first
+func (r *SQLRepository) promoCodeError(p *Promotion, err error) error {
+	var count int
+	row := r.db.QueryRow(r.dialect.rebind(`SELECT COUNT(1) FROM promotions WHERE code = ? AND id <> ?`), p.Code, p.ID)
+	if row.Scan(&count) == nil && count > 0 {
+		return ErrDuplicatePromoCode
+	}
+	return err
+}
+
+// Update represent update promotion repository, it fails with ErrPromoVersionConflict when
+// the promo has been updated since it was read and bumps the version of p on success
+func (r *SQLRepository) Update(p *Promotion) error {
+	return r.UpdateMany([]*Promotion{p})
+}
+
+// UpdateMany represent update of several promotions at once, none is written when one of them conflicts
+func (r *SQLRepository) UpdateMany(promos []*Promotion) error {
+	return r.SaveBooking(&BookingChange{Promos: promos})
+}
+
+// SaveBooking represent update of the promos and save of the holds and the redemptions of a booking in one
+// transaction, none is written when a promo conflicts, a settled hold is no longer held or the booking has already
+// redeemed one of the promos
+func (r *SQLRepository) SaveBooking(c *BookingChange) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range c.Promos {
+		if err = r.updatePromotion(tx, p); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if c.Guest != "" {
+		if err = r.updateGuest(tx, c.Guest, c.GuestVersion); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, h := range c.Holds {
+		if err = r.insertHold(tx, h); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, h := range c.Settled {
+		if err = r.updateHold(tx, h); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, rd := range c.Redemptions {
+		if err = r.insertRedemption(tx, rd); err != nil {
+			tx.Rollback()
+			return r.redemptionError(rd, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, p := range c.Promos {
+		p.Version++
+	}
+	return nil
+}
+
+func (r *SQLRepository) updatePromotion(tx *sql.Tx, p *Promotion) error {
+	res, err := tx.Exec(r.dialect.rebind(`UPDATE promotions SET
+		title = ?, code = ?, start_date = ?, end_date = ?, percentage = ?, amount = ?, currency = ?,
+		max_discount = ?, min_price = ?, min_spend = ?, scope = ?, timezone = ?, distribution_strategy = ?,
+		qty = ?, allocated = ?, reedem = ?, balance = ?, status = ?,
+		stay_start = ?, stay_end = ?, stay_days = ?, blackout_dates = ?, calendar_id = ?,
+		rules = ?, slots = ?, guest_limit = ?, guest_daily_limit = ?, first_booking = ?,
+		stackable = ?, exclusive_group = ?, public = ?, auto_apply = ?, version = version + 1
+		WHERE id = ? AND version = ?`),
+		p.Title, p.Code, utcTime(p.StartDate.Ptr()), utcTime(p.EndDate.Ptr()), p.Percentage, p.Amount, p.currency(),
+		p.MaxDiscount, p.MinPrice, p.MinSpend, p.scope(), p.Timezone, p.Strategy,
+		p.Qty, p.Allocated, p.Redeem, p.Balance, p.Status,
+		utcTime(p.StayStart.Ptr()), utcTime(p.StayEnd.Ptr()), p.StayDays, p.BlackoutDates, p.CalendarID,
+		p.Rules, p.Slots, p.GuestLimit, p.DailyLimit, p.FirstBooking, p.Stackable, p.ExclusiveGroup, p.Public, p.AutoApply,
+		p.ID, p.Version,
+	)
+	if err == nil {
+		err = expectAffected(res, ErrPromoVersionConflict)
+	}
+	if err == ErrPromoVersionConflict {
+		var count int
+		if tx.QueryRow(r.dialect.rebind(`SELECT COUNT(1) FROM promotions WHERE id = ?`), p.ID).Scan(&count) == nil && count == 0 {
+			err = ErrPromoNotFound
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.saveDistribution(tx, p.Distribution)
+}
+
+func (r *SQLRepository) saveDistribution(tx *sql.Tx, d *PromoDistribution) error {
+	if d == nil {
+		return nil
+	}
+
+	_, err := tx.Exec(r.dialect.rebind(`INSERT INTO promo_distribution_days (`+distributionColumns+`)
+		VALUES (`+placeholders(11)+`)
+		ON CONFLICT (promo_id, date) DO UPDATE SET qty = excluded.qty, reedem = excluded.reedem, balance = excluded.balance,
+			carried = excluded.carried, allocated = excluded.allocated, distributed_at = excluded.distributed_at, next_run_at = excluded.next_run_at,
+			slot_start = excluded.slot_start, slot_balance = excluded.slot_balance`),
+		d.PromoID, d.Date, d.Qty, d.Redeem, d.Balance, d.Carried, d.Allocated, utcTime(d.DistributedAt.Ptr()), utcTime(d.NextRunAt.Ptr()),
+		utcTime(d.SlotStart.Ptr()), d.SlotBalance,
+	)
+	return err
+}
+
+// GetDistributionLedger represent get the distributed days of a promo from and to a date, ordered by date. An
+// empty from or to leaves the range open
+func (r *SQLRepository) GetDistributionLedger(promoID uuid.UUID, from, to string) ([]*PromoDistribution, error) {
+	query := `SELECT ` + distributionColumns + ` FROM promo_distribution_days WHERE promo_id = ?`
+	args := []interface{}{promoID}
+	if from != "" {
+		query += ` AND date >= ?`
+		args = append(args, from)
+	}
+	if to != "" {
+		query += ` AND date <= ?`
+		args = append(args, to)
+	}
+
+	rows, err := r.db.Query(r.dialect.rebind(query+` ORDER BY date`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*PromoDistribution{}
+	for rows.Next() {
+		d := &PromoDistribution{}
+		err = rows.Scan(&d.PromoID, &d.Date, &d.Qty, &d.Redeem, &d.Balance, &d.Carried, &d.Allocated, &d.DistributedAt, &d.NextRunAt,
+			&d.SlotStart, &d.SlotBalance)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// GetRedemptionsByBookingRef represent get redemptions of a booking
+func (r *SQLRepository) GetRedemptionsByBookingRef(ref string) ([]*Redemption, error) {
+	return r.queryRedemptions(`WHERE booking_ref = ? ORDER BY created_at`, ref)
+}
+
+// GetRedemptionsByGuest represent get redemptions of a guest
+func (r *SQLRepository) GetRedemptionsByGuest(guest string) ([]*Redemption, error) {
+	return r.queryRedemptions(`WHERE guest = ? ORDER BY created_at`, guest)
+}
+
+func (r *SQLRepository) queryRedemptions(query string, args ...interface{}) ([]*Redemption, error) {
+	rows, err := r.db.Query(r.dialect.rebind(`SELECT `+redemptionColumns+` FROM promo_redemptions `+query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*Redemption{}
+	for rows.Next() {
+		rd := &Redemption{}
+		err = rows.Scan(&rd.ID, &rd.PromoID, &rd.Code, &rd.BookingRef, &rd.Guest, &rd.Currency, &rd.OriginalPrice, &rd.PromoPrice, &rd.FinalPrice, &rd.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rd)
+	}
+	return res, rows.Err()
+}
+
+// SaveRedemption represent save redemption repository
+func (r *SQLRepository) SaveRedemption(rd *Redemption) error {
+	if err := r.insertRedemption(r.db, rd); err != nil {
+		return r.redemptionError(rd, err)
+	}
+	return nil
+}
+
+func (r *SQLRepository) insertRedemption(db execer, rd *Redemption) error {
+	_, err := db.Exec(r.dialect.rebind(`INSERT INTO promo_redemptions (`+redemptionColumns+`) VALUES (`+placeholders(10)+`)`),
+		rd.ID, rd.PromoID, rd.Code, rd.BookingRef, rd.Guest, rd.Currency, rd.OriginalPrice, rd.PromoPrice, rd.FinalPrice, rd.CreatedAt.UTC(),
+	)
+	return err
+}
+
+// redemptionError turns the error of saving rd into ErrBookingAlreadyRedeemed when the unique booking_ref and
+// promo_id index rejected it, the transaction of the insert has to be rolled back first
+func (r *SQLRepository) redemptionError(rd *Redemption, err error) error {
+	var count int
+	row := r.db.QueryRow(r.dialect.rebind(`SELECT COUNT(1) FROM promo_redemptions WHERE booking_ref = ? AND promo_id = ?`),
+		rd.BookingRef, rd.PromoID)
+	if row.Scan(&count) == nil && count > 0 {
+		return ErrBookingAlreadyRedeemed
+	}
+	return err
+}
+
+func (r *SQLRepository) queryHolds(query string, args ...interface{}) ([]*Hold, error) {
+	rows, err := r.db.Query(r.dialect.rebind(`SELECT `+holdColumns+` FROM promo_holds `+query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*Hold{}
+	for rows.Next() {
+		h := &Hold{}
+		err = rows.Scan(&h.ID, &h.PromoID, &h.Code, &h.BookingRef, &h.Guest, &h.Status, &h.Currency, &h.OriginalPrice, &h.PromoPrice, &h.FinalPrice, &h.CreatedAt, &h.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, h)
+	}
+	return res, rows.Err()
+}
+
+// GetHoldsByBookingRef represent get reservation holds of a booking
+func (r *SQLRepository) GetHoldsByBookingRef(ref string) ([]*Hold, error) {
+	return r.queryHolds(`WHERE booking_ref = ? ORDER BY created_at`, ref)
+}
+
+// GetHoldsByGuest represent get reservation holds of a guest
+func (r *SQLRepository) GetHoldsByGuest(guest string) ([]*Hold, error) {
+	return r.queryHolds(`WHERE guest = ? ORDER BY created_at`, guest)
+}
+
+// GetGuestVersion represent get how often the guest has booked, it is zero for a guest who has not booked
+func (r *SQLRepository) GetGuestVersion(guest string) (int64, error) {
+	var version int64
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT version FROM promo_guests WHERE guest = ?`), guest).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// updateGuest bumps the version of the guest, it fails with ErrPromoVersionConflict when the guest has booked
+// since version was read
+func (r *SQLRepository) updateGuest(tx *sql.Tx, guest string, version int64) error {
+	query := `UPDATE promo_guests SET version = version + 1 WHERE guest = ? AND version = ?`
+	args := []interface{}{guest, version}
+	if version == 0 {
+		query = `INSERT INTO promo_guests (guest, version) VALUES (?, 1) ON CONFLICT (guest) DO NOTHING`
+		args = args[:1]
+	}
+
+	res, err := tx.Exec(r.dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrPromoVersionConflict)
+}
+
+// GetExpiredHolds represent get holds which are still held but expired at the given time
+func (r *SQLRepository) GetExpiredHolds(t time.Time) ([]*Hold, error) {
+	return r.queryHolds(`WHERE status = ? AND expires_at <= ? ORDER BY expires_at`, HoldStatusHeld, t.UTC())
+}
+
+// SaveHold represent save reservation hold repository
+func (r *SQLRepository) SaveHold(h *Hold) error {
+	return r.insertHold(r.db, h)
+}
+
+func (r *SQLRepository) insertHold(db execer, h *Hold) error {
+	_, err := db.Exec(r.dialect.rebind(`INSERT INTO promo_holds (`+holdColumns+`) VALUES (`+placeholders(12)+`)`),
+		h.ID, h.PromoID, h.Code, h.BookingRef, h.Guest, h.Status, h.Currency, h.OriginalPrice, h.PromoPrice, h.FinalPrice, h.CreatedAt.UTC(), h.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+// UpdateHold represent update reservation hold repository, it fails with ErrHoldNotHeld when the hold has been
+// confirmed, cancelled or expired since it was read
+func (r *SQLRepository) UpdateHold(h *Hold) error {
+	return r.updateHold(r.db, h)
+}
+
+func (r *SQLRepository) updateHold(db execer, h *Hold) error {
+	res, err := db.Exec(r.dialect.rebind(`UPDATE promo_holds SET status = ?, expires_at = ? WHERE id = ? AND status = ?`),
+		h.Status, h.ExpiresAt.UTC(), h.ID, HoldStatusHeld,
+	)
+	if err == nil {
+		err = expectAffected(res, ErrHoldNotHeld)
+	}
+	if err == ErrHoldNotHeld {
+		var count int
+		if db.QueryRow(r.dialect.rebind(`SELECT COUNT(1) FROM promo_holds WHERE id = ?`), h.ID).Scan(&count) == nil && count == 0 {
+			err = ErrHoldNotFound
+		}
+	}
+	return err
+}
+
+// GetCalendarByID represent get blackout calendar by ID
+func (r *SQLRepository) GetCalendarByID(id uuid.UUID) (*BlackoutCalendar, error) {
+	calendars, err := r.queryCalendars(`WHERE c.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(calendars) == 0 {
+		return nil, ErrCalendarNotFound
+	}
+	return calendars[0], nil
+}
+
+// GetAllCalendars represent get all blackout calendars
+func (r *SQLRepository) GetAllCalendars() ([]*BlackoutCalendar, error) {
+	return r.queryCalendars(``)
+}
+
+func (r *SQLRepository) queryCalendars(query string, args ...interface{}) ([]*BlackoutCalendar, error) {
+	rows, err := r.db.Query(r.dialect.rebind(`SELECT c.id, c.name, d.date, d.name FROM blackout_calendars c
+		LEFT JOIN blackout_calendar_dates d ON d.calendar_id = c.id `+query+` ORDER BY c.name, c.id, d.date`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*BlackoutCalendar{}
+	var calendar *BlackoutCalendar
+	for rows.Next() {
+		var id uuid.UUID
+		var name string
+		var date, dateName sql.NullString
+		if err = rows.Scan(&id, &name, &date, &dateName); err != nil {
+			return nil, err
+		}
+
+		if calendar == nil || calendar.ID != id {
+			calendar = &BlackoutCalendar{ID: id, Name: name, Dates: []*CalendarDate{}}
+			res = append(res, calendar)
+		}
+		if date.Valid {
+			calendar.Dates = append(calendar.Dates, &CalendarDate{Date: date.String, Name: dateName.String})
+		}
+	}
+	return res, rows.Err()
+}
+
+// SaveCalendar represent save blackout calendar repository
+func (r *SQLRepository) SaveCalendar(c *BlackoutCalendar) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(r.dialect.rebind(`INSERT INTO blackout_calendars (id, name) VALUES (?, ?)`), c.ID, c.Name)
+	if err == nil {
+		err = r.saveCalendarDates(tx, c)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateCalendar represent update blackout calendar repository, the dates are replaced
+func (r *SQLRepository) UpdateCalendar(c *BlackoutCalendar) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(r.dialect.rebind(`UPDATE blackout_calendars SET name = ? WHERE id = ?`), c.Name, c.ID)
+	if err == nil {
+		err = expectAffected(res, ErrCalendarNotFound)
+	}
+	if err == nil {
+		_, err = tx.Exec(r.dialect.rebind(`DELETE FROM blackout_calendar_dates WHERE calendar_id = ?`), c.ID)
+	}
+	if err == nil {
+		err = r.saveCalendarDates(tx, c)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCalendar represent delete blackout calendar repository
+func (r *SQLRepository) DeleteCalendar(id uuid.UUID) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(r.dialect.rebind(`DELETE FROM blackout_calendar_dates WHERE calendar_id = ?`), id)
+	if err == nil {
+		var res sql.Result
+		res, err = tx.Exec(r.dialect.rebind(`DELETE FROM blackout_calendars WHERE id = ?`), id)
+		if err == nil {
+			err = expectAffected(res, ErrCalendarNotFound)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLRepository) saveCalendarDates(tx *sql.Tx, c *BlackoutCalendar) error {
+	for _, d := range c.Dates {
+		_, err := tx.Exec(r.dialect.rebind(`INSERT INTO blackout_calendar_dates (calendar_id, date, name) VALUES (?, ?, ?)`),
+			c.ID, d.Date, d.Name,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AcquireLock represent take the lock by name until the given time, it fails when another owner holds a lock
+// which has not expired at now. The owner of the lock may take it again to extend it
+func (r *SQLRepository) AcquireLock(name, owner string, now, until time.Time) (bool, error) {
+	res, err := r.db.Exec(r.dialect.rebind(`INSERT INTO job_locks (name, owner, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE job_locks.owner = excluded.owner OR job_locks.expires_at <= ?`),
+		name, owner, until.UTC(), now.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ReleaseLock represent release the lock by name when owner holds it
+func (r *SQLRepository) ReleaseLock(name, owner string) error {
+	_, err := r.db.Exec(r.dialect.rebind(`DELETE FROM job_locks WHERE name = ? AND owner = ?`), name, owner)
+	return err
+}
+
+// GetJobRun represent get the last run of a job
+func (r *SQLRepository) GetJobRun(job string) (*JobRun, error) {
+	run := &JobRun{}
+	err := r.db.QueryRow(r.dialect.rebind(`SELECT job, instance, started_at, finished_at, distributed, error
+		FROM job_runs WHERE job = ?`), job,
+	).Scan(&run.Job, &run.Instance, &run.StartedAt, &run.FinishedAt, &run.Distributed, &run.Error)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// SaveJobRun represent save the last run of a job, it replaces the run before
+func (r *SQLRepository) SaveJobRun(run *JobRun) error {
+	_, err := r.db.Exec(r.dialect.rebind(`INSERT INTO job_runs (job, instance, started_at, finished_at, distributed, error)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (job) DO UPDATE SET instance = excluded.instance, started_at = excluded.started_at,
+			finished_at = excluded.finished_at, distributed = excluded.distributed, error = excluded.error`),
+		run.Job, run.Instance, run.StartedAt.UTC(), run.FinishedAt.UTC(), run.Distributed, run.Error,
+	)
+	return err
+}
+
+func expectAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// placeholders returns n comma separated bind placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// utcTime keeps stored timestamps comparable, SQLite compares them as text
+func utcTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// NewSQLRepository initiate Repository backed by database/sql, the schema is migrated on start
+func NewSQLRepository(db *sql.DB, dialect Dialect) (Repository, error) {
+	if dialect != DialectSQLite && dialect != DialectPostgres {
+		return nil, fmt.Errorf("Unsupported SQL dialect '%s'", dialect)
+	}
+
+	if err := Migrate(db, dialect); err != nil {
+		return nil, err
+	}
+
+	return &SQLRepository{db: db, dialect: dialect}, nil
+}
@@ -0,0 +1,94 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var ErrPromoCodeRequired = errors.New("Promo code is required")
+
+// StackOrder represent the order stacked promos are applied to a room price
+type StackOrder string
+
+const (
+	StackOrderPercentageFirst StackOrder = "percentage_first"
+	StackOrderAmountFirst     StackOrder = "amount_first"
+)
+
+// ParseStackOrder parse string into StackOrder, empty string is percentage first
+func ParseStackOrder(v string) (StackOrder, error) {
+	switch StackOrder(v) {
+	case "", StackOrderPercentageFirst:
+		return StackOrderPercentageFirst, nil
+	case StackOrderAmountFirst:
+		return StackOrderAmountFirst, nil
+	}
+
+	return "", fmt.Errorf("invalid stack order '%s'", v)
+}
+
+// StackError represent promos which can not be used together
+type StackError struct {
+	Reason string
+}
+
+func (e *StackError) Error() string {
+	return e.Reason
+}
+
+// PromoBreakdown represent what a single promo contributed to a room price
+type PromoBreakdown struct {
+	PromoID uuid.UUID    `json:"promoId"`
+	Code    string       `json:"code"`
+	Saving  Decimal      `json:"saving"`
+	Message string       `json:"message"`
+	Trace   []*RuleTrace `json:"trace,omitempty"`
+}
+
+// validateStack checks the promos may be combined, every promo of a stack must be stackable
+// and at most one promo of an exclusive group may be used
+func validateStack(promos []*Promotion) error {
+	if len(promos) == 0 {
+		return ErrPromoCodeRequired
+	}
+
+	codes := map[string]bool{}
+	groups := map[string]string{}
+	for _, promo := range promos {
+		if codes[promo.Code] {
+			return &StackError{fmt.Sprintf("Promo %s is given more than once", promo.Code)}
+		}
+		codes[promo.Code] = true
+
+		if len(promos) == 1 {
+			continue
+		}
+
+		if !promo.Stackable {
+			return &StackError{fmt.Sprintf("Promo %s can not be combined with other promos", promo.Code)}
+		}
+
+		if promo.ExclusiveGroup.Valid {
+			if other, ok := groups[promo.ExclusiveGroup.String]; ok {
+				return &StackError{fmt.Sprintf("Promo %s and %s are exclusive to each other", other, promo.Code)}
+			}
+			groups[promo.ExclusiveGroup.String] = promo.Code
+		}
+	}
+
+	return nil
+}
+
+// sortStack orders the promos the way they are applied, promos of the same kind keep the requested order
+func sortStack(promos []*Promotion, order StackOrder) {
+	sort.SliceStable(promos, func(i, j int) bool {
+		iPercentage, jPercentage := promos[i].Percentage.Valid, promos[j].Percentage.Valid
+		if order == StackOrderAmountFirst {
+			return !iPercentage && jPercentage
+		}
+		return iPercentage && !jPercentage
+	})
+}
@@ -0,0 +1,120 @@
+package promotion
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidStatusTransition = errors.New("Promo status transition is not allowed")
+
+// PromoStatus represent lifecycle state of the promo
+type PromoStatus string
+
+const (
+	StatusDraft     PromoStatus = "draft"
+	StatusScheduled PromoStatus = "scheduled"
+	StatusActive    PromoStatus = "active"
+	StatusPaused    PromoStatus = "paused"
+	StatusExhausted PromoStatus = "exhausted"
+	StatusExpired   PromoStatus = "expired"
+	StatusArchived  PromoStatus = "archived"
+)
+
+// statusTransitions lists the statuses every status may move to. Scheduled, active, exhausted and expired
+// follow the promo dates and balance so they move freely between each other, e.g. an edit that raises the
+// quota brings an exhausted promo back to active
+var statusTransitions = map[PromoStatus][]PromoStatus{
+	StatusDraft:     {StatusScheduled, StatusActive, StatusExhausted, StatusExpired, StatusArchived},
+	StatusScheduled: {StatusActive, StatusExhausted, StatusExpired, StatusPaused, StatusArchived},
+	StatusActive:    {StatusScheduled, StatusExhausted, StatusExpired, StatusPaused, StatusArchived},
+	StatusExhausted: {StatusScheduled, StatusActive, StatusExpired, StatusPaused, StatusArchived},
+	StatusExpired:   {StatusScheduled, StatusActive, StatusExhausted, StatusArchived},
+	StatusPaused:    {StatusScheduled, StatusActive, StatusExhausted, StatusExpired, StatusArchived},
+	StatusArchived:  {},
+}
+
+// CanTransitionTo reports whether the lifecycle allows moving from s to next
+func (s PromoStatus) CanTransitionTo(next PromoStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// transition moves the promo to next when the lifecycle allows it
+func (p *Promotion) transition(next PromoStatus) error {
+	if !p.Status.CanTransitionTo(next) {
+		return ErrInvalidStatusTransition
+	}
+
+	p.Status = next
+	return nil
+}
+
+// scheduledStatus is the status the promo has by its dates and balance alone
+func (p *Promotion) scheduledStatus(now time.Time) PromoStatus {
+	if p.EndDate.Valid && now.After(p.EndDate.Time) {
+		return StatusExpired
+	}
+
+	if p.StartDate.Valid && now.Before(p.StartDate.Time) {
+		return StatusScheduled
+	}
+
+	if p.Balance <= 0 {
+		return StatusExhausted
+	}
+
+	return StatusActive
+}
+
+// refreshStatus moves the promo along its dates and balance and reports whether the status changed.
+// Draft and archived promos never move by themselves, a paused promo only expires
+func (p *Promotion) refreshStatus(now time.Time) bool {
+	next := p.scheduledStatus(now)
+	switch p.Status {
+	case StatusDraft, StatusArchived:
+		return false
+	case StatusPaused:
+		if next != StatusExpired {
+			return false
+		}
+	}
+
+	if next == p.Status {
+		return false
+	}
+
+	p.Status = next
+	return true
+}
+
+// publish moves a draft promo to the status its dates and balance give it
+func (p *Promotion) publish(now time.Time) error {
+	if p.Status != StatusDraft {
+		return ErrInvalidStatusTransition
+	}
+
+	return p.transition(p.scheduledStatus(now))
+}
+
+// pause stops a promo from being applied until it is resumed
+func (p *Promotion) pause() error {
+	return p.transition(StatusPaused)
+}
+
+// resume moves a paused promo back to the status its dates and balance give it
+func (p *Promotion) resume(now time.Time) error {
+	if p.Status != StatusPaused {
+		return ErrInvalidStatusTransition
+	}
+
+	return p.transition(p.scheduledStatus(now))
+}
+
+// archive retires the promo for good
+func (p *Promotion) archive() error {
+	return p.transition(StatusArchived)
+}
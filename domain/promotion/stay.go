@@ -0,0 +1,192 @@
+package promotion
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"gopkg.in/guregu/null.v3"
+)
+
+// MaxNights is the longest stay of a room a promo is applied to
+const MaxNights = 365
+
+var ErrInvalidNight = errors.New("Night is out of range")
+var ErrInvalidRoomDate = errors.New("Invalid Date")
+
+// DateList represent a list of dates in utils.DateLayout, stored as a JSON array
+type DateList []string
+
+// Value implements the driver Valuer interface
+func (l DateList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the Scanner interface
+func (l *DateList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	}
+
+	return fmt.Errorf("can not scan %T into DateList", value)
+}
+
+// clone returns a copy of the list which shares no array with l
+func (l DateList) clone() DateList {
+	if l == nil {
+		return nil
+	}
+	return append(DateList{}, l...)
+}
+
+// contains reports whether the date of t is in the list
+func (l DateList) contains(t time.Time) bool {
+	date := t.Format(utils.DateLayout)
+	for _, d := range l {
+		if d == date {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks every date of the list
+func (l DateList) validate() error {
+	for _, d := range l {
+		if _, err := utils.ParseDate(d); err != nil {
+			return fmt.Errorf("Blackout Date '%s' is invalid", d)
+		}
+	}
+	return nil
+}
+
+// NightResponse represent the price of a single night of a room stay
+type NightResponse struct {
+	Date       time.Time `json:"date"`
+	Price      Decimal   `json:"price"`
+	PromoPrice Decimal   `json:"promoPrice"`
+	Saving     Decimal   `json:"saving"`
+}
+
+// stayNights returns the date of every night of the stay, a room without nights stays one night
+func stayNights(checkin time.Time, night null.Int) []time.Time {
+	n := int64(1)
+	if night.Valid && night.Int64 > 1 {
+		n = night.Int64
+	}
+
+	nights := make([]time.Time, n)
+	for i := range nights {
+		nights[i] = checkin.AddDate(0, 0, i)
+	}
+	return nights
+}
+
+// newNightResponses splits the room price evenly over the nights of the stay
+func newNightResponses(nights []time.Time, price Decimal) []*NightResponse {
+	weights := make([]Decimal, len(nights))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	res := make([]*NightResponse, len(nights))
+	for i, share := range allocate(price, weights) {
+		res[i] = &NightResponse{Date: nights[i], Price: share, PromoPrice: share}
+	}
+	return res
+}
+
+// eligibleNight checks a single night against the stay window, the stay days and the blackout dates
+func (p *Promotion) eligibleNight(t time.Time) (bool, error) {
+	date := t.Format(utils.DateLayout)
+	if p.StayStart.Valid && date < p.StayStart.Time.Format(utils.DateLayout) {
+		return false, nil
+	}
+
+	if p.StayEnd.Valid && date > p.StayEnd.Time.Format(utils.DateLayout) {
+		return false, nil
+	}
+
+	if p.BlackoutDates.contains(t) {
+		return false, nil
+	}
+
+	if p.StayDays.Valid {
+		stayDays, err := utils.ParseWeekdays(p.StayDays.String)
+		if err != nil {
+			return false, newFailure(FailureInvalidPromo, "Promo Stay Day is Invalid", nil)
+		}
+		return stayDays.Contains(t.Weekday()), nil
+	}
+
+	return true, nil
+}
+
+// stayRule returns which nights of the stay the promo applies to, it fails when no night is eligible
+func (p *Promotion) stayRule(nights []*NightResponse) ([]bool, error) {
+	eligible := make([]bool, len(nights))
+	found := false
+	for i, night := range nights {
+		ok, err := p.eligibleNight(night.Date)
+		if err != nil {
+			return nil, err
+		}
+		eligible[i] = ok
+		found = found || ok
+	}
+
+	if !found {
+		params := map[string]interface{}{}
+		if p.StayStart.Valid {
+			params["stayStart"] = p.StayStart.Time.Format(utils.DateLayout)
+		}
+		if p.StayEnd.Valid {
+			params["stayEnd"] = p.StayEnd.Time.Format(utils.DateLayout)
+		}
+		if p.StayDays.Valid {
+			params["stayDays"] = p.StayDays.String
+		}
+		if len(p.BlackoutDates) > 0 {
+			params["blackoutDates"] = p.BlackoutDates
+		}
+		return nil, newFailure(FailureStayDates, "Stay dates rule is failed", params)
+	}
+	return eligible, nil
+}
+
+// spreadSaving splits the saving of the room over its nights in proportion to the night price left. Only the
+// eligible nights keep their share, it returns the saving which is actually taken
+func spreadSaving(nights []*NightResponse, saving Decimal, eligible []bool) Decimal {
+	weights := make([]Decimal, len(nights))
+	for i, night := range nights {
+		weights[i] = night.PromoPrice
+	}
+
+	taken := Decimal(0)
+	for i, share := range allocate(saving, weights) {
+		if eligible != nil && !eligible[i] {
+			continue
+		}
+		nights[i].PromoPrice -= share
+		nights[i].Saving += share
+		taken += share
+	}
+	return taken
+}
@@ -0,0 +1,141 @@
+package promotion
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+)
+
+// BookingWindow represent a time of day range bookings are accepted in, Start and End are HH:MM and End is
+// exclusive. A window whose end is not after its start wraps past midnight, one starting and ending at the same
+// time lasts the whole day. Days limits the weekdays the window starts on, every day when it is empty
+type BookingWindow struct {
+	Days  string `json:"days,omitempty"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// WindowList represent the booking windows of a promo, stored as a JSON array
+type WindowList []*BookingWindow
+
+// Value implements the driver Valuer interface
+func (l WindowList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal([]*BookingWindow(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the Scanner interface
+func (l *WindowList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]*BookingWindow)(l))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]*BookingWindow)(l))
+	}
+
+	return fmt.Errorf("can not scan %T into WindowList", value)
+}
+
+// clone returns a copy of the list which shares no pointers with l
+func (l WindowList) clone() WindowList {
+	if l == nil {
+		return nil
+	}
+
+	res := make(WindowList, len(l))
+	for i, w := range l {
+		window := *w
+		res[i] = &window
+	}
+	return res
+}
+
+// validate checks the days and the times of every window
+func (l WindowList) validate() error {
+	for _, w := range l {
+		if _, err := w.contains(time.Time{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contains reports whether the wall clock of t falls in the window, the part of a window wrapping past midnight
+// belongs to the day the window starts on
+func (w *BookingWindow) contains(t time.Time) (bool, error) {
+	start, err := utils.ParseTimeOfDay(w.Start)
+	if err != nil {
+		return false, fmt.Errorf("Booking Window start is invalid: %v", err)
+	}
+
+	end, err := utils.ParseTimeOfDay(w.End)
+	if err != nil {
+		return false, fmt.Errorf("Booking Window end is invalid: %v", err)
+	}
+
+	days := utils.NewWeekdaySet(time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday)
+	if w.Days != "" {
+		if days, err = utils.ParseWeekdays(w.Days); err != nil {
+			return false, fmt.Errorf("Booking Window days is invalid: %v", err)
+		}
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	today, yesterday := days.Contains(t.Weekday()), days.Contains((t.Weekday()+6)%7)
+	switch {
+	case start == end:
+		return today, nil
+	case start < end:
+		return today && minute >= start && minute < end, nil
+	}
+
+	return (today && minute >= start) || (yesterday && minute < end), nil
+}
+
+// BookingWindowRule represent the time of day windows a booking may be made in, the booking has to fall in one
+type BookingWindowRule struct {
+	Windows WindowList `json:"windows"`
+}
+
+func (r *BookingWindowRule) Type() string {
+	return RuleBookingWindow
+}
+
+func (r *BookingWindowRule) Check(ctx *RuleContext) error {
+	if len(r.Windows) == 0 {
+		return nil
+	}
+
+	for _, w := range r.Windows {
+		ok, err := w.contains(ctx.BookingTime)
+		if err != nil {
+			return newFailure(FailureInvalidPromo, "Promo Booking Window is Invalid", nil)
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return newFailure(FailureBookingHour, "booking window rule is failed", map[string]interface{}{
+		"windows":  r.Windows,
+		"timezone": ctx.BookingTime.Location().String(),
+		"actual":   ctx.BookingTime.Format("15:04"),
+	})
+}
+
+func (r *BookingWindowRule) Validate() error {
+	return r.Windows.validate()
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	domainPromo "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+)
+
+// maxCalendarFileSize bounds the size of an imported iCalendar file
+const maxCalendarFileSize = 1 << 20
+
+func (h *Handler) CreateCalendar(w http.ResponseWriter, r *http.Request) {
+	var req domainPromo.CalendarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	calendar, err := h.service.CreateCalendar(req)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusCreated, calendar)
+}
+
+// ImportCalendar creates a calendar from an iCalendar file, sent either as the request body or as the file
+// field of a multipart form. The name query or form value overrides the calendar name of the file
+func (h *Handler) ImportCalendar(w http.ResponseWriter, r *http.Request) {
+	var file io.Reader = http.MaxBytesReader(w, r.Body, maxCalendarFileSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxCalendarFileSize); err != nil {
+			Error(w, http.StatusBadRequest, err, err.Error())
+			return
+		}
+
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			Error(w, http.StatusBadRequest, err, "Calendar file is required")
+			return
+		}
+		defer f.Close()
+		file = f
+	}
+
+	calendar, err := h.service.ImportCalendar(r.FormValue("name"), file)
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusCreated, calendar)
+}
+
+func (h *Handler) GetCalendars(w http.ResponseWriter, r *http.Request) {
+	calendars, err := h.service.GetCalendars()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, calendars)
+}
+
+func (h *Handler) GetCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, "Invalid Calendar ID")
+		return
+	}
+
+	calendar, err := h.service.GetCalendar(id)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, calendar)
+}
+
+// UpdateCalendar replaces the name and the dates of the calendar with the request body
+func (h *Handler) UpdateCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, "Invalid Calendar ID")
+		return
+	}
+
+	var req domainPromo.CalendarRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	if err = req.Validate(); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	calendar, err := h.service.UpdateCalendar(id, req)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, calendar)
+}
+
+func (h *Handler) DeleteCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, "Invalid Calendar ID")
+		return
+	}
+
+	if err = h.service.DeleteCalendar(id); err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	domainPromo "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -28,28 +29,156 @@ func (h *Handler) CreatePromo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := req.Validate()
+	uid, err := uuid.NewV4()
 	if err != nil {
-		Error(w, http.StatusBadRequest, err, err.Error())
+		Error(w, http.StatusInternalServerError, err, err.Error())
 		return
 	}
 
-	uid, err := uuid.NewV4()
+	// the request is validated as it becomes a promo
+	promo, err := req.ToPromo(uid)
 	if err != nil {
-		Error(w, http.StatusInternalServerError, err, err.Error())
+		Error(w, http.StatusBadRequest, err, err.Error())
 		return
 	}
 
-	promo, err := req.ToPromo(uid)
 	promotion, err := h.service.CreatePromotion(promo)
 	if err != nil {
-		Error(w, http.StatusInternalServerError, err, err.Error())
+		Error(w, promoErrorStatus(err), err, err.Error())
 		return
 	}
 
 	JSON(w, http.StatusCreated, promotion)
 }
 
+func (h *Handler) GetPromo(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, "Invalid Promo ID")
+		return
+	}
+
+	promotion, err := h.service.GetPromotion(id)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, promotion)
+}
+
+// GetPromoDistribution lists the distribution ledger of a promo, from and to bound the days (YYYY-MM-DD)
+func (h *Handler) GetPromoDistribution(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, "Invalid Promo ID")
+		return
+	}
+
+	query := r.URL.Query()
+	res, err := h.service.GetDistributionLedger(id, query.Get("from"), query.Get("to"))
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetPromoByCode(w http.ResponseWriter, r *http.Request) {
+	promotion, err := h.service.GetPromotionByCode(mux.Vars(r)["code"])
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, promotion)
+}
+
+// UpdatePromo replaces the promo definition with the request body (PUT)
+func (h *Handler) UpdatePromo(w http.ResponseWriter, r *http.Request) {
+	h.editPromo(w, r, false)
+}
+
+// PatchPromo changes only the fields present in the request body (PATCH)
+func (h *Handler) PatchPromo(w http.ResponseWriter, r *http.Request) {
+	h.editPromo(w, r, true)
+}
+
+func (h *Handler) editPromo(w http.ResponseWriter, r *http.Request, partial bool) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, "Invalid Promo ID")
+		return
+	}
+
+	var req domainPromo.PromoRequest
+	if partial {
+		current, err := h.service.GetPromotion(id)
+		if err != nil {
+			Error(w, promoErrorStatus(err), err, err.Error())
+			return
+		}
+		req = domainPromo.NewPromoRequest(current)
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	promotion, err := h.service.UpdatePromotion(id, req)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, promotion)
+}
+
+// DeletePromo soft deletes the promo by archiving it
+func (h *Handler) DeletePromo(w http.ResponseWriter, r *http.Request) {
+	h.changePromoStatus(w, r, h.service.ArchivePromotion)
+}
+
+func (h *Handler) PublishPromo(w http.ResponseWriter, r *http.Request) {
+	h.changePromoStatus(w, r, h.service.PublishPromotion)
+}
+
+func (h *Handler) PausePromo(w http.ResponseWriter, r *http.Request) {
+	h.changePromoStatus(w, r, h.service.PausePromotion)
+}
+
+func (h *Handler) ResumePromo(w http.ResponseWriter, r *http.Request) {
+	h.changePromoStatus(w, r, h.service.ResumePromotion)
+}
+
+func (h *Handler) ArchivePromo(w http.ResponseWriter, r *http.Request) {
+	h.changePromoStatus(w, r, h.service.ArchivePromotion)
+}
+
+func (h *Handler) changePromoStatus(w http.ResponseWriter, r *http.Request, change func(uuid.UUID) (*domainPromo.Promotion, error)) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		Error(w, http.StatusBadRequest, err, "Invalid Promo ID")
+		return
+	}
+
+	promotion, err := change(id)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, promotion)
+}
+
 func (h *Handler) ApplyPromo(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var req domainPromo.ApplyPromoRequest
@@ -60,13 +189,138 @@ func (h *Handler) ApplyPromo(w http.ResponseWriter, r *http.Request) {
 
 	res, err := h.service.ApplyPromotion(req)
 	if err != nil {
-		Error(w, http.StatusNotFound, err, err.Error())
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, res)
+}
+
+// PreviewPromo prices the rooms as if the booking is made at bookingTime of the request, for admin simulations
+func (h *Handler) PreviewPromo(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var req domainPromo.ApplyPromoRequest
+	if err := decoder.Decode(&req); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	res, err := h.service.PreviewPromotion(req)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) BestPromo(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var req domainPromo.ApplyPromoRequest
+	if err := decoder.Decode(&req); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	res, err := h.service.BestPromotion(req)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) RedeemPromo(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var req domainPromo.RedeemPromoRequest
+	if err := decoder.Decode(&req); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	res, err := h.service.RedeemPromotion(req)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusCreated, res)
+}
+
+func (h *Handler) ReservePromo(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var req domainPromo.ReservePromoRequest
+	if err := decoder.Decode(&req); err != nil {
+		Error(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	res, err := h.service.ReservePromotion(req)
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusCreated, res)
+}
+
+func (h *Handler) ConfirmReservation(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.ConfirmReservation(mux.Vars(r)["bookingRef"])
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
 		return
 	}
 
 	JSON(w, http.StatusOK, res)
 }
 
+func (h *Handler) CancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.CancelReservation(mux.Vars(r)["bookingRef"])
+	if err != nil {
+		Error(w, promoErrorStatus(err), err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) ExpireReservations(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.ExpireHolds()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, res)
+}
+
+func promoErrorStatus(err error) int {
+	if _, ok := err.(*domainPromo.StackError); ok {
+		return http.StatusUnprocessableEntity
+	}
+
+	switch err {
+	case domainPromo.ErrPromoNotFound:
+		return http.StatusNotFound
+	case domainPromo.ErrHoldNotFound, domainPromo.ErrCalendarNotFound:
+		return http.StatusNotFound
+	case domainPromo.ErrBookingRefRequired, domainPromo.ErrInvalidHoldTTL, domainPromo.ErrQuotaBelowUsed,
+		domainPromo.ErrPromoCodeRequired, domainPromo.ErrInvalidCurrency, domainPromo.ErrInvalidBookingTime, domainPromo.ErrInvalidDateRange,
+		domainPromo.ErrInvalidNight, domainPromo.ErrInvalidRoomDate:
+		return http.StatusBadRequest
+	case domainPromo.ErrBookingAlreadyRedeemed, domainPromo.ErrBookingAlreadyReserved, domainPromo.ErrHoldNotHeld, domainPromo.ErrPromoQuotaExhausted, domainPromo.ErrPromoVersionConflict,
+		domainPromo.ErrDuplicatePromoCode, domainPromo.ErrInvalidStatusTransition, domainPromo.ErrCalendarInUse, domainPromo.ErrPromoSlotExhausted:
+		return http.StatusConflict
+	case domainPromo.ErrHoldExpired:
+		return http.StatusGone
+	case domainPromo.ErrPromoNotApplied, domainPromo.ErrCurrencyMismatch:
+		return http.StatusUnprocessableEntity
+	}
+
+	return http.StatusInternalServerError
+}
+
 func (h *Handler) PromoDistribution(w http.ResponseWriter, r *http.Request) {
 	err := h.service.PromoDistribution()
 	if err != nil {
@@ -77,6 +331,16 @@ func (h *Handler) PromoDistribution(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusOK, true)
 }
 
+func (h *Handler) SchedulerStatus(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.SchedulerStatus()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, err, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, res)
+}
+
 func (h *Handler) GetAvailablePromo(w http.ResponseWriter, r *http.Request) {
 	res, err := h.service.GetAvailablePromo()
 	if err != nil {
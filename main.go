@@ -1,24 +1,102 @@
 package main
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
+	"os"
+	"time"
+	// embed the IANA timezone database, promo timezones must load on hosts without one
+	_ "time/tzdata"
 
 	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
 	h "github.com/chandrafortuna/simple-promotion-api/handler"
 	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
+const uuidPattern = "[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}"
+
+// newRepository chooses the promo repository from PROMO_DB_DRIVER (memory, sqlite3 or postgres) and PROMO_DB_DSN
+func newRepository() (p.Repository, error) {
+	driver := os.Getenv("PROMO_DB_DRIVER")
+	if driver == "" || driver == "memory" {
+		return p.NewRepository([]*p.Promotion{}), nil
+	}
+
+	dsn := os.Getenv("PROMO_DB_DSN")
+	if dsn == "" && driver == string(p.DialectSQLite) {
+		dsn = "promo.db"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver == string(p.DialectSQLite) {
+		// sqlite allows a single writer, serialize access instead of failing with database is locked
+		db.SetMaxOpenConns(1)
+	}
+
+	return p.NewSQLRepository(db, p.Dialect(driver))
+}
+
 func main() {
 	//register service
-	promoRepository := p.NewRepository([]*p.Promotion{})
-	promoService := p.NewService(promoRepository)
+	promoRepository, err := newRepository()
+	if err != nil {
+		log.Fatal("Failed to initiate repository: ", err)
+	}
+	stackOrder, err := p.ParseStackOrder(os.Getenv("PROMO_STACK_ORDER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	rounding, err := p.ParseRounding(os.Getenv("PROMO_ROUNDING"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	schedule, err := p.ParseSchedule(os.Getenv("PROMO_DISTRIBUTE_CRON"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	promoService := p.NewService(promoRepository).WithStackOrder(stackOrder).WithRounding(rounding).WithSchedule(schedule)
+	if instance := os.Getenv("PROMO_INSTANCE"); instance != "" {
+		promoService = promoService.WithInstance(instance)
+	}
 	handler := h.NewHandler(promoService)
+	promoService.StartSweeper(time.Minute, nil)
+	promoService.StartScheduler(time.Minute, nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/promo", handler.CreatePromo).Methods("POST")
 	router.HandleFunc("/promo", handler.GetAvailablePromo).Methods("GET")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}", handler.GetPromo).Methods("GET")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}", handler.UpdatePromo).Methods("PUT")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}", handler.PatchPromo).Methods("PATCH")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}", handler.DeletePromo).Methods("DELETE")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}/publish", handler.PublishPromo).Methods("POST")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}/pause", handler.PausePromo).Methods("POST")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}/resume", handler.ResumePromo).Methods("POST")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}/archive", handler.ArchivePromo).Methods("POST")
+	router.HandleFunc("/promo/{id:"+uuidPattern+"}/distribution", handler.GetPromoDistribution).Methods("GET")
+	router.HandleFunc("/promo/code/{code}", handler.GetPromoByCode).Methods("GET")
 	router.HandleFunc("/promo/apply", handler.ApplyPromo).Methods("POST")
+	router.HandleFunc("/promo/preview", handler.PreviewPromo).Methods("POST")
+	router.HandleFunc("/promo/best", handler.BestPromo).Methods("POST")
+	router.HandleFunc("/promo/redeem", handler.RedeemPromo).Methods("POST")
+	router.HandleFunc("/promo/reserve", handler.ReservePromo).Methods("POST")
+	router.HandleFunc("/promo/reservations/expire", handler.ExpireReservations).Methods("POST")
+	router.HandleFunc("/promo/reservations/{bookingRef}/confirm", handler.ConfirmReservation).Methods("POST")
+	router.HandleFunc("/promo/reservations/{bookingRef}/cancel", handler.CancelReservation).Methods("POST")
+	router.HandleFunc("/promo/calendars", handler.CreateCalendar).Methods("POST")
+	router.HandleFunc("/promo/calendars", handler.GetCalendars).Methods("GET")
+	router.HandleFunc("/promo/calendars/import", handler.ImportCalendar).Methods("POST")
+	router.HandleFunc("/promo/calendars/{id:"+uuidPattern+"}", handler.GetCalendar).Methods("GET")
+	router.HandleFunc("/promo/calendars/{id:"+uuidPattern+"}", handler.UpdateCalendar).Methods("PUT")
+	router.HandleFunc("/promo/calendars/{id:"+uuidPattern+"}", handler.DeleteCalendar).Methods("DELETE")
 	router.HandleFunc("/promo/distribute", handler.PromoDistribution).Methods("POST")
+	router.HandleFunc("/promo/admin/scheduler", handler.SchedulerStatus).Methods("GET")
 	log.Fatal(http.ListenAndServe(":8000", router))
 }
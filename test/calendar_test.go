@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+const holidaysICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"X-WR-CALNAME:Holidays\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20201224\r\n" +
+	"DTEND;VALUE=DATE:20201226\r\n" +
+	"SUMMARY:Christmas\\, Eve and Day\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20201231T170000Z\r\n" +
+	"SUMMARY:New Year\r\n" +
+	"  Eve\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// jakartaICS holds timed events in a calendar of Asia/Jakarta (UTC+7), the dates are the dates in Jakarta
+const jakartaICS = "BEGIN:VCALENDAR\r\n" +
+	"X-WR-CALNAME:Jakarta\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20201231T170000Z\r\n" +
+	"SUMMARY:New Year\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=America/New_York:20210213T200000\r\n" +
+	"DTEND;TZID=America/New_York:20210214T110000\r\n" +
+	"SUMMARY:Valentine\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20210817T090000\r\n" +
+	"DTEND:20210818T000000\r\n" +
+	"SUMMARY:Independence Day\r\n" +
+	"END:VEVENT\r\n" +
+	"X-WR-TIMEZONE:Asia/Jakarta\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalendar(t *testing.T) {
+	req, err := p.ParseICalendar(strings.NewReader(holidaysICS))
+	assert.Nil(t, err)
+	assert.Equal(t, "Holidays", req.Name)
+	assert.Equal(t, []*p.CalendarDate{
+		{Date: "2020-12-24", Name: "Christmas, Eve and Day"},
+		{Date: "2020-12-25", Name: "Christmas, Eve and Day"},
+		{Date: "2020-12-31", Name: "New Year Eve"},
+	}, req.Dates)
+
+	// an event of a TZID in a UTC calendar falls on its date in UTC
+	req, err = p.ParseICalendar(strings.NewReader("BEGIN:VEVENT\r\n" +
+		"DTSTART;TZID=Asia/Jakarta:20210101T050000\r\nSUMMARY:Early\r\nEND:VEVENT\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []*p.CalendarDate{{Date: "2020-12-31", Name: "Early"}}, req.Dates)
+
+	_, err = p.ParseICalendar(strings.NewReader("BEGIN:VEVENT\r\n" +
+		"DTSTART;TZID=Nowhere/Land:20210101T050000\r\nEND:VEVENT\r\n"))
+	assert.NotNil(t, err)
+	_, err = p.ParseICalendar(strings.NewReader(strings.Replace(jakartaICS, "Asia/Jakarta", "Nowhere/Land", 1)))
+	assert.NotNil(t, err)
+	_, err = p.ParseICalendar(strings.NewReader("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	assert.NotNil(t, err)
+	_, err = p.ParseICalendar(strings.NewReader("BEGIN:VEVENT\r\nDTSTART:2020\r\nEND:VEVENT\r\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseICalendarTimezone(t *testing.T) {
+	req, err := p.ParseICalendar(strings.NewReader(jakartaICS))
+	assert.Nil(t, err)
+	assert.Equal(t, []*p.CalendarDate{
+		// 17:00 UTC is midnight in Jakarta
+		{Date: "2021-01-01", Name: "New Year"},
+		// 08:00 to 23:00 in Jakarta
+		{Date: "2021-02-14", Name: "Valentine"},
+		// a timed end at midnight is exclusive, the event does not cover the next day
+		{Date: "2021-08-17", Name: "Independence Day"},
+	}, req.Dates)
+}
+
+func TestFunctionPromoCalendar(t *testing.T) {
+	holiday := newQuotaPromo("HOLIDAY10", 5)
+	service := p.NewService(p.NewRepository([]*p.Promotion{holiday}))
+
+	calendar, err := service.ImportCalendar("Peak Season", strings.NewReader(holidaysICS))
+	assert.Nil(t, err)
+	assert.Equal(t, "Peak Season", calendar.Name)
+	assert.Len(t, calendar.Dates, 3)
+
+	_, err = service.CreateCalendar(p.CalendarRequest{Name: "Invalid", Dates: []*p.CalendarDate{{Date: "2020-02-30"}}})
+	assert.NotNil(t, err)
+
+	req := p.PromoRequest{Code: "HOLIDAY10", Percentage: null.IntFrom(10), Quota: 5, CalendarID: &calendar.ID}
+	_, err = service.UpdatePromotion(holiday.ID, req)
+	assert.Nil(t, err)
+
+	missing := calendar.ID
+	missing[0]++
+	req.CalendarID = &missing
+	_, err = service.UpdatePromotion(holiday.ID, req)
+	assert.Equal(t, p.ErrCalendarNotFound, err)
+
+	room := &p.RoomRequest{Date: "2020-12-23 14:00:00", Room: "Christmas", Price: p.NewDecimal(200000), Night: null.IntFrom(2)}
+	res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "HOLIDAY10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice)
+	assert.Equal(t, "Promo not applied: Promo is not available on 2020-12-24 (Christmas, Eve and Day), blackout date of calendar Peak Season",
+		res.Rooms[0].Message)
+
+	room = &p.RoomRequest{Date: "2020-12-26 14:00:00", Room: "Boxing Day", Price: p.NewDecimal(200000), Night: null.IntFrom(2)}
+	res, err = service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "HOLIDAY10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(20000), res.PromoPrice)
+
+	assert.Equal(t, p.ErrCalendarInUse, service.DeleteCalendar(calendar.ID))
+
+	updated, err := service.UpdateCalendar(calendar.ID, p.CalendarRequest{Name: "Christmas", Dates: []*p.CalendarDate{{Date: "2020-12-27"}}})
+	assert.Nil(t, err)
+	assert.Len(t, updated.Dates, 1)
+	res, err = service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "HOLIDAY10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice)
+
+	req.CalendarID = nil
+	_, err = service.UpdatePromotion(holiday.ID, req)
+	assert.Nil(t, err)
+	assert.Nil(t, service.DeleteCalendar(calendar.ID))
+	_, err = service.GetCalendar(calendar.ID)
+	assert.Equal(t, p.ErrCalendarNotFound, err)
+}
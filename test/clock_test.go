@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestFunctionPreviewPromo(t *testing.T) {
+	previewPromo := newQuotaPromo("PREVIEW10", 5)
+	previewPromo.StartDate = null.TimeFrom(start)
+	previewPromo.EndDate = null.TimeFrom(end)
+	afterSale, _ := utils.ParseTimeFromString("2020-03-01 09:00:00")
+	previewService := p.NewService(p.NewRepository([]*p.Promotion{previewPromo})).WithClock(p.FixedClock(afterSale))
+
+	room := &p.RoomRequest{Date: "2020-02-16 14:00:00", Room: "Deluxe", Price: p.NewDecimal(100000)}
+	req := p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "PREVIEW10", BookingTime: null.StringFrom("2020-02-16 09:00:00")}
+
+	res, err := previewService.ApplyPromotion(req)
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice, "apply ignores the booking time of the request")
+	assert.Equal(t, "Promo not applied: Promo is not available; Promo is not started or has been ended", res.Rooms[0].Message)
+
+	res, err = previewService.PreviewPromotion(req)
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(10000), res.PromoPrice)
+
+	req.BookingTime = null.StringFrom("2020-02-16T02:00:00+07:00")
+	res, err = previewService.PreviewPromotion(req)
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(10000), res.PromoPrice)
+
+	req.BookingTime = null.String{}
+	res, err = previewService.PreviewPromotion(req)
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice, "the preview defaults to the service clock")
+
+	req.BookingTime = null.StringFrom("yesterday")
+	_, err = previewService.PreviewPromotion(req)
+	assert.Equal(t, p.ErrInvalidBookingTime, err)
+}
+
+func TestFunctionDistributeClock(t *testing.T) {
+	req := p.PromoRequest{
+		Code:       "CLOCKQUOTA",
+		Percentage: null.IntFrom(10),
+		Quota:      100,
+		StartDate:  null.StringFrom("2020-02-01 00:00:00"),
+		EndDate:    null.StringFrom("2020-02-10 00:00:00"),
+	}
+	id, _ := uuid.NewV4()
+	quotaPromo, err := req.ToPromo(id)
+	assert.Nil(t, err)
+
+	midSale, _ := utils.ParseTimeFromString("2020-02-06 00:00:00")
+	clockService := p.NewService(p.NewRepository([]*p.Promotion{})).WithClock(p.FixedClock(midSale))
+	created, err := clockService.CreatePromotion(quotaPromo)
+	assert.Nil(t, err)
+	assert.Equal(t, p.StatusActive, created.Status)
+	assert.Equal(t, int64(20), created.Distribution.Qty, "the quota is spread over the 5 days left")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestFunctionConditionRules(t *testing.T) {
+	var req p.PromoRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"code": "WEEKENDER",
+		"percentage": 10,
+		"quota": 5,
+		"rules": [{"type": "or", "params": {"rules": [
+			{"type": "and", "params": {"rules": [
+				{"type": "min_night", "params": {"min": 2}},
+				{"type": "checkin_day", "params": {"days": "Friday"}}
+			]}},
+			{"type": "min_room", "params": {"min": 3}}
+		]}}]
+	}`), &req))
+	assert.Nil(t, req.Validate())
+	promo, err := req.ToPromo(newQuotaPromo("WEEKENDER", 5).ID)
+	assert.Nil(t, err)
+
+	bookedAt, _ := utils.ParseTimeFromString("2020-02-10 09:00:00")
+	service := p.NewService(p.NewRepository([]*p.Promotion{promo})).WithClock(p.FixedClock(bookedAt))
+	friday := &p.RoomRequest{Date: "2020-02-14 14:00:00", Room: "Friday", Price: p.NewDecimal(100000), Night: null.IntFrom(2), Qty: null.IntFrom(1)}
+	group := &p.RoomRequest{Date: "2020-02-15 14:00:00", Room: "Group", Price: p.NewDecimal(100000), Night: null.IntFrom(1), Qty: null.IntFrom(3)}
+	single := &p.RoomRequest{Date: "2020-02-15 14:00:00", Room: "Single", Price: p.NewDecimal(100000), Night: null.IntFrom(1), Qty: null.IntFrom(1)}
+	res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{friday, group, single}, Code: "WEEKENDER"})
+	assert.Nil(t, err)
+
+	trace := res.Rooms[0].Promos[0].Trace[0]
+	assert.Equal(t, p.NewDecimal(10000), res.Rooms[0].Saving)
+	assert.Equal(t, "or", trace.Type)
+	assert.True(t, trace.Passed)
+	assert.True(t, trace.Rules[0].Passed, "the first branch matched")
+	assert.False(t, trace.Rules[1].Passed)
+	assert.Equal(t, []p.FailureCode{"MIN_ROOM"}, failureCodes(trace.Rules[1].Failures))
+
+	trace = res.Rooms[1].Promos[0].Trace[0]
+	assert.Equal(t, p.NewDecimal(10000), res.Rooms[1].Saving)
+	assert.False(t, trace.Rules[0].Passed)
+	assert.Equal(t, []p.FailureCode{"MIN_NIGHT", "CHECKIN_DAY"}, failureCodes(trace.Rules[0].Failures))
+	assert.True(t, trace.Rules[1].Passed, "the second branch matched")
+
+	trace = res.Rooms[2].Promos[0].Trace[0]
+	assert.Equal(t, p.NewDecimal(0), res.Rooms[2].Saving)
+	assert.False(t, trace.Passed)
+	assert.Equal(t, []p.FailureCode{"CONDITION"}, failureCodes(res.Rooms[2].Failures))
+	assert.Equal(t, "Promo not applied: None of the conditions is met", res.Rooms[2].Message)
+	assert.Len(t, res.Rooms[2].Failures[0].Params["options"], 2)
+
+	b, err := json.Marshal(res.Rooms[0].Promos[0].Trace)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `{"type":"min_night","params":{"min":2},"passed":true}`)
+}
+
+func TestFunctionNotRule(t *testing.T) {
+	promo := newQuotaPromo("NOTSUNDAY", 5)
+	promo.Rules = p.RuleList{&p.NotRule{Rules: p.RuleList{&p.CheckinDayRule{Days: "Sunday"}}}}
+	bookedAt, _ := utils.ParseTimeFromString("2020-02-10 09:00:00")
+	service := p.NewService(p.NewRepository([]*p.Promotion{promo})).WithClock(p.FixedClock(bookedAt))
+
+	saturday := &p.RoomRequest{Date: "2020-02-15 14:00:00", Room: "Saturday", Price: p.NewDecimal(100000)}
+	sunday := &p.RoomRequest{Date: "2020-02-16 14:00:00", Room: "Sunday", Price: p.NewDecimal(100000)}
+	res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{saturday, sunday}, Code: "NOTSUNDAY"})
+	assert.Nil(t, err)
+	assert.True(t, res.Rooms[0].Promos[0].Trace[0].Passed)
+	assert.Equal(t, []p.FailureCode{"CONDITION"}, failureCodes(res.Rooms[1].Failures))
+	assert.Equal(t, []string{"checkin_day"}, res.Rooms[1].Failures[0].Params["not"])
+}
+
+func TestConditionValidate(t *testing.T) {
+	invalid := []string{
+		`[{"type": "or", "params": {"rules": []}}]`,
+		`[{"type": "not", "params": {}}]`,
+		`[{"type": "and", "params": {"rules": [{"type": "or", "params": {"rules": [
+			{"type": "checkin_day", "params": {"days": "Someday"}}
+		]}}]}}]`,
+	}
+	for _, rules := range invalid {
+		req := p.PromoRequest{Code: "CONDITION", Percentage: null.IntFrom(10)}
+		assert.Nil(t, json.Unmarshal([]byte(rules), &req.Rules))
+		assert.NotNil(t, req.Validate(), rules)
+	}
+
+	var rules p.RuleList
+	assert.NotNil(t, json.Unmarshal([]byte(`[{"type": "or", "params": {"rules": [{"type": "unknown"}]}}]`), &rules))
+}
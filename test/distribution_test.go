@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+// greedyStrategy asks for far more than the quota on the first day, it is only known to the tests
+type greedyStrategy struct{}
+
+func (greedyStrategy) Weights(days []time.Time) []int64 {
+	weights := make([]int64, len(days))
+	weights[0] = 1000
+	return weights
+}
+
+func (greedyStrategy) Carry() bool {
+	return true
+}
+
+func init() {
+	p.RegisterDistributionStrategy("greedy", greedyStrategy{})
+}
+
+// distributeDaily creates a promo of 10 quota from Thursday to Sunday with the strategy and distributes it every
+// midnight, use takes quota of every day. It returns the daily quota and the quota allocated at the end
+func distributeDaily(t *testing.T, strategy string, use []int64) ([]int64, int64) {
+	req := p.PromoRequest{
+		Code:       "STRATEGY",
+		Percentage: null.IntFrom(10),
+		Quota:      10,
+		Strategy:   strategy,
+		StartDate:  null.StringFrom("2020-02-13 00:00:00"),
+		EndDate:    null.StringFrom("2020-02-16 00:00:00"),
+	}
+	promo, err := req.ToPromo(newQuotaPromo("STRATEGY", 10).ID)
+	assert.Nil(t, err)
+
+	now, _ := utils.ParseTimeFromString("2020-02-13 00:00:00")
+	repo := p.NewRepository([]*p.Promotion{})
+	service := p.NewService(repo).WithClock(p.ClockFunc(func() time.Time { return now }))
+	_, err = service.CreatePromotion(promo)
+	assert.Nil(t, err)
+
+	daily := []int64{}
+	for day := 0; day < 4; day++ {
+		if day > 0 {
+			now = now.AddDate(0, 0, 1)
+			_, err = service.DistributeDue()
+			assert.Nil(t, err)
+		}
+
+		promo, _ = repo.GetPromotionByCode("STRATEGY")
+		daily = append(daily, promo.Distribution.Qty)
+		if day < len(use) {
+			promo.Balance -= use[day]
+			promo.Distribution.Balance -= use[day]
+			assert.Nil(t, repo.Update(promo))
+		}
+	}
+
+	promo, _ = repo.GetPromotionByCode("STRATEGY")
+	return daily, promo.Allocated
+}
+
+func TestFunctionDistributionStrategies(t *testing.T) {
+	cases := []struct {
+		strategy string
+		use      []int64
+		daily    []int64
+	}{
+		{"", nil, []int64{3, 3, 2, 2}},
+		{"even", []int64{1, 1, 1, 1}, []int64{3, 3, 2, 2}},
+		{"front_loaded", nil, []int64{4, 3, 2, 1}},
+		{"weekday_weighted", nil, []int64{2, 2, 3, 3}},
+		{"carry_over", []int64{1, 0, 2, 0}, []int64{3, 5, 7, 7}},
+		{"greedy", []int64{4, 0, 0, 0}, []int64{10, 6, 6, 6}},
+	}
+
+	for _, c := range cases {
+		daily, allocated := distributeDaily(t, c.strategy, c.use)
+		assert.Equal(t, c.daily, daily, c.strategy)
+		assert.True(t, allocated <= 10, "%s allocated %d", c.strategy, allocated)
+	}
+}
+
+func TestFunctionDistributionStrategyUnknown(t *testing.T) {
+	req := p.PromoRequest{Code: "STRATEGY", Percentage: null.IntFrom(10), Quota: 10, Strategy: "random"}
+	assert.NotNil(t, req.Validate())
+	_, err := req.ToPromo(newQuotaPromo("STRATEGY", 10).ID)
+	assert.NotNil(t, err)
+
+	assert.Panics(t, func() {
+		p.RegisterDistributionStrategy("even", greedyStrategy{})
+	})
+}
+
+func TestFunctionDistributionLedger(t *testing.T) {
+	req := p.PromoRequest{
+		Code:       "LEDGER",
+		Percentage: null.IntFrom(10),
+		Quota:      10,
+		Strategy:   p.DistributionCarryOver,
+		StartDate:  null.StringFrom("2020-02-13 00:00:00"),
+		EndDate:    null.StringFrom("2020-02-16 00:00:00"),
+	}
+	promo, err := req.ToPromo(newQuotaPromo("LEDGER", 10).ID)
+	assert.Nil(t, err)
+
+	now, _ := utils.ParseTimeFromString("2020-02-13 00:00:00")
+	repo := p.NewRepository([]*p.Promotion{})
+	service := p.NewService(repo).WithClock(p.ClockFunc(func() time.Time { return now }))
+	_, err = service.CreatePromotion(promo)
+	assert.Nil(t, err)
+
+	for day, use := range []int64{1, 0, 2, 0} {
+		if day > 0 {
+			now = now.AddDate(0, 0, 1)
+			_, err = service.DistributeDue()
+			assert.Nil(t, err)
+		}
+
+		promo, _ = repo.GetPromotionByCode("LEDGER")
+		promo.Redeem += use
+		promo.Balance -= use
+		promo.Distribution.Redeem += use
+		promo.Distribution.Balance -= use
+		assert.Nil(t, repo.Update(promo))
+	}
+
+	ledger, err := service.GetDistributionLedger(promo.ID, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), ledger[3].Expired, "the last day is still running")
+
+	now = now.AddDate(0, 0, 1)
+	ledger, err = service.GetDistributionLedger(promo.ID, "", "")
+	assert.Nil(t, err)
+	dates, allocated, carried, redeem, expired := []string{}, []int64{}, []int64{}, []int64{}, []int64{}
+	for _, d := range ledger {
+		dates = append(dates, d.Date)
+		allocated = append(allocated, d.Allocated)
+		carried = append(carried, d.Carried)
+		redeem = append(redeem, d.Redeem)
+		expired = append(expired, d.Expired)
+	}
+	assert.Equal(t, []string{"2020-02-13", "2020-02-14", "2020-02-15", "2020-02-16"}, dates)
+	assert.Equal(t, []int64{3, 3, 2, 2}, allocated)
+	assert.Equal(t, []int64{0, 2, 5, 5}, carried)
+	assert.Equal(t, []int64{1, 0, 2, 0}, redeem)
+	assert.Equal(t, []int64{0, 0, 0, 7}, expired, "the quota left expires when the promo ends")
+
+	ledger, err = service.GetDistributionLedger(promo.ID, "2020-02-14", "2020-02-15")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ledger))
+	assert.Equal(t, "2020-02-14", ledger[0].Date)
+	assert.Equal(t, int64(0), ledger[1].Expired, "the next day is read to know what was carried")
+
+	_, err = service.GetDistributionLedger(promo.ID, "2020-02-15", "2020-02-14")
+	assert.Equal(t, p.ErrInvalidDateRange, err)
+	_, err = service.GetDistributionLedger(promo.ID, "15-02-2020", "")
+	assert.Equal(t, p.ErrInvalidDateRange, err)
+	_, err = service.GetDistributionLedger(newQuotaPromo("MISSING", 1).ID, "", "")
+	assert.Equal(t, p.ErrPromoNotFound, err)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func failureCodes(failures []*p.RuleFailure) []p.FailureCode {
+	codes := []p.FailureCode{}
+	for _, f := range failures {
+		codes = append(codes, f.Code)
+	}
+	return codes
+}
+
+func TestFunctionRuleFailures(t *testing.T) {
+	strict := newQuotaPromo("STRICT10", 5)
+	strict.StartDate = null.TimeFrom(start)
+	strict.EndDate = null.TimeFrom(end)
+	strict.Rules = p.RuleList{
+		&p.MinNightRule{Min: 3},
+		&p.MinRoomRule{Min: 2},
+		&p.CheckinDayRule{Days: "Sunday"},
+		&p.BookingWindowRule{Windows: p.WindowList{{Start: "20:00", End: "22:00"}}},
+	}
+	bookedAt, _ := utils.ParseTimeFromString("2020-02-16 09:00:00")
+	strictService := p.NewService(p.NewRepository([]*p.Promotion{strict})).WithClock(p.FixedClock(bookedAt))
+
+	monday := &p.RoomRequest{Date: "2020-02-17 14:00:00", Room: "Monday", Price: p.NewDecimal(100000), Night: null.IntFrom(1), Qty: null.IntFrom(1)}
+	res, err := strictService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{monday}, Code: "STRICT10"})
+	assert.Nil(t, err)
+	room := res.Rooms[0]
+	assert.Equal(t, []p.FailureCode{p.FailureMinNight, p.FailureMinRoom, p.FailureCheckinDay, p.FailureBookingHour}, failureCodes(room.Failures),
+		"every failed rule is reported")
+	assert.Equal(t, "Promo not applied: Min Night rule is failed; Min Room rule is failed; checkin time rule is failed; booking window rule is failed",
+		room.Message)
+
+	minNight := room.Failures[0]
+	assert.Equal(t, strict.ID, minNight.PromoID)
+	assert.Equal(t, "STRICT10", minNight.PromoCode)
+	assert.Equal(t, int64(3), minNight.Params["required"])
+	assert.Equal(t, int64(1), minNight.Params["actual"])
+	assert.Equal(t, "Sunday", room.Failures[2].Params["days"])
+	assert.Equal(t, "Monday", room.Failures[2].Params["actual"])
+	assert.Equal(t, "09:00", room.Failures[3].Params["actual"])
+
+	b, err := json.Marshal(minNight)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"promoId":"`+strict.ID.String()+`","promoCode":"STRICT10","code":"MIN_NIGHT",
+		"message":"Min Night rule is failed","params":{"required":3,"actual":1}}`, string(b))
+
+	soldOut := newQuotaPromo("SOLDOUT", 0)
+	soldOut.Stackable = true
+	spend := newQuotaPromo("SPEND10", 5)
+	spend.Stackable = true
+	spend.MinSpend = p.NullDecimalFrom(p.NewDecimal(500000))
+	stackService := p.NewService(p.NewRepository([]*p.Promotion{soldOut, spend}))
+	res, err = stackService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{monday}, Codes: []string{"SOLDOUT", "SPEND10"}})
+	assert.Nil(t, err)
+	room = res.Rooms[0]
+	assert.Equal(t, []p.FailureCode{p.FailureQuotaExhausted, p.FailureMinSpend}, failureCodes(room.Failures))
+	assert.Equal(t, "Promo not applied: SOLDOUT: Promo quota is exhausted; SPEND10: Min Spend rule is failed, spend at least 500000 IDR",
+		room.Message)
+	assert.Equal(t, p.NewDecimal(500000), room.Failures[1].Params["required"])
+
+	res, err = stackService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{monday}, Code: "SPEND10"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Promo not applied: Min Spend rule is failed, spend at least 500000 IDR", res.Rooms[0].Message,
+		"a single promo is not named")
+}
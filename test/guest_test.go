@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestFunctionGuestLimits(t *testing.T) {
+	now := time.Date(2020, 2, 16, 10, 0, 0, 0, time.UTC)
+	limited := newQuotaPromo("GUEST2", 10)
+	limited.GuestLimit = null.IntFrom(2)
+	limited.DailyLimit = null.IntFrom(1)
+	welcome := newQuotaPromo("WELCOME", 10)
+	welcome.FirstBooking = true
+	repo := p.NewRepository([]*p.Promotion{limited, welcome})
+	service := p.NewService(repo).WithClock(p.ClockFunc(func() time.Time { return now }))
+
+	room := &p.RoomRequest{Date: "2020-02-20 14:00:00", Room: "Deluxe", Price: p.NewDecimal(100000), Night: null.IntFrom(1), Qty: null.IntFrom(1)}
+	apply := func(code, guest string) []p.FailureCode {
+		res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: code, Guest: guest})
+		assert.Nil(t, err)
+		return failureCodes(res.Rooms[0].Failures)
+	}
+	redeem := func(code, guest, ref string) error {
+		_, err := service.RedeemPromotion(p.RedeemPromoRequest{
+			ApplyPromoRequest: p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: code, Guest: guest},
+			BookingRef:        ref,
+		})
+		return err
+	}
+
+	assert.Equal(t, []p.FailureCode{p.FailureGuestRequired}, apply("GUEST2", ""), "a promo limited per guest needs the guest")
+	assert.Equal(t, []p.FailureCode{}, apply("GUEST2", "guest-1"))
+	assert.Equal(t, []p.FailureCode{}, apply("WELCOME", "guest-1"))
+
+	assert.Nil(t, redeem("GUEST2", " guest-1 ", "BOOKING-1"))
+	assert.Equal(t, []p.FailureCode{p.FailureGuestDailyLimit}, apply("GUEST2", "guest-1"))
+	assert.Equal(t, p.ErrPromoNotApplied, redeem("GUEST2", "guest-1", "BOOKING-2"), "the limit is enforced at redemption")
+	assert.Equal(t, []p.FailureCode{p.FailureFirstBooking}, apply("WELCOME", "guest-1"))
+	assert.Equal(t, []p.FailureCode{}, apply("GUEST2", "guest-2"), "the limits are per guest")
+
+	now = now.AddDate(0, 0, 1)
+	assert.Nil(t, redeem("GUEST2", "guest-1", "BOOKING-2"))
+	now = now.AddDate(0, 0, 1)
+	res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "GUEST2", Guest: "guest-1"})
+	assert.Nil(t, err)
+	failures := res.Rooms[0].Failures
+	assert.Equal(t, []p.FailureCode{p.FailureGuestLimit}, failureCodes(failures))
+	assert.Equal(t, int64(2), failures[0].Params["limit"])
+	assert.Equal(t, int64(2), failures[0].Params["used"])
+}
+
+func TestFunctionGuestLimitsReservation(t *testing.T) {
+	now := time.Date(2020, 2, 16, 10, 0, 0, 0, time.UTC)
+	once := newQuotaPromo("ONCE", 10)
+	once.GuestLimit = null.IntFrom(1)
+	service := p.NewService(p.NewRepository([]*p.Promotion{once})).WithClock(p.ClockFunc(func() time.Time { return now }))
+
+	room := &p.RoomRequest{Date: "2020-02-20 14:00:00", Room: "Deluxe", Price: p.NewDecimal(100000), Night: null.IntFrom(1), Qty: null.IntFrom(1)}
+	reserve := func(ref string) error {
+		_, err := service.ReservePromotion(p.ReservePromoRequest{
+			RedeemPromoRequest: p.RedeemPromoRequest{
+				ApplyPromoRequest: p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "ONCE", Guest: "guest-1"},
+				BookingRef:        ref,
+			},
+			TTL: 600,
+		})
+		return err
+	}
+
+	assert.Nil(t, reserve("BOOKING-1"))
+	assert.Equal(t, p.ErrPromoNotApplied, reserve("BOOKING-2"), "a held reservation counts as a use")
+
+	_, err := service.CancelReservation("BOOKING-1")
+	assert.Nil(t, err)
+	assert.Nil(t, reserve("BOOKING-2"))
+
+	res, err := service.ConfirmReservation("BOOKING-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "guest-1", res.Redemptions[0].Guest, "the redemption keeps the guest of the reservation")
+}
+
+// racingRepository runs race once while the guest history is read, like a booking of the guest made by another
+// instance in between
+type racingRepository struct {
+	p.Repository
+	race func()
+}
+
+func (r *racingRepository) GetHoldsByGuest(guest string) ([]*p.Hold, error) {
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+	return r.Repository.GetHoldsByGuest(guest)
+}
+
+func TestFunctionGuestLimitsConcurrent(t *testing.T) {
+	now := time.Date(2020, 2, 16, 10, 0, 0, 0, time.UTC)
+	welcome := newQuotaPromo("WELCOMERACE", 10)
+	welcome.FirstBooking = true
+	repo := newSQLiteRepository(t)
+	assert.Nil(t, repo.Save(welcome))
+	assert.Nil(t, repo.Save(newQuotaPromo("OTHERRACE", 10)))
+	racing := &racingRepository{Repository: repo}
+	clock := p.FixedClock(now)
+	service := p.NewService(racing).WithClock(clock)
+	other := p.NewService(repo).WithClock(clock)
+
+	room := &p.RoomRequest{Date: "2020-02-20 14:00:00", Room: "Deluxe", Price: p.NewDecimal(100000), Night: null.IntFrom(1), Qty: null.IntFrom(1)}
+	redeem := func(s *p.Service, code, ref string) error {
+		_, err := s.RedeemPromotion(p.RedeemPromoRequest{
+			ApplyPromoRequest: p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: code, Guest: "guest-1"},
+			BookingRef:        ref,
+		})
+		return err
+	}
+
+	racing.race = func() {
+		assert.Nil(t, redeem(&other, "OTHERRACE", "BOOKING-1"))
+	}
+	assert.Equal(t, p.ErrPromoNotApplied, redeem(&service, "WELCOMERACE", "BOOKING-2"),
+		"a booking of the guest saved by another instance while the history is read counts")
+
+	redemptions, _ := repo.GetRedemptionsByGuest("guest-1")
+	assert.Equal(t, 1, len(redemptions))
+	saved, _ := repo.GetPromotionByCode("WELCOMERACE")
+	assert.Equal(t, int64(10), saved.Balance)
+}
+
+func TestFunctionGuestLimitsInvalid(t *testing.T) {
+	req := p.PromoRequest{Code: "GUEST", Percentage: null.IntFrom(10), Quota: 10, GuestLimit: null.IntFrom(0)}
+	assert.NotNil(t, req.Validate())
+	_, err := req.ToPromo(newQuotaPromo("GUEST", 10).ID)
+	assert.NotNil(t, err)
+
+	req.GuestLimit = null.IntFrom(3)
+	req.FirstBooking = true
+	promo, err := req.ToPromo(newQuotaPromo("GUEST", 10).ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), promo.GuestLimit.Int64)
+	assert.True(t, promo.FirstBooking)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestDecimal(t *testing.T) {
+	d, err := p.ParseDecimal("0.1")
+	assert.Nil(t, err)
+	sum := p.Decimal(0)
+	for i := 0; i < 10; i++ {
+		sum += d
+	}
+	assert.Equal(t, p.NewDecimal(1), sum, "sums must not drift")
+
+	d, err = p.ParseDecimal("10.005")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.01", d.String(), "extra fraction digits round half up")
+
+	_, err = p.ParseDecimal("1O0")
+	assert.NotNil(t, err)
+
+	price, _ := p.ParseDecimal("99.95")
+	assert.Equal(t, "10", price.Percent(10).String(), "9.995 rounds half up")
+	assert.Equal(t, "149900", p.NewDecimal(1499499).Percent(10).Round(p.RoundNearest100).String())
+	assert.Equal(t, "15000", p.NewDecimal(149950).Percent(10).Round(p.RoundNearest100).String())
+
+	var room p.RoomRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{"price": 150000.25}`), &room))
+	assert.Equal(t, "150000.25", room.Price.String())
+	out, _ := json.Marshal(room.Price)
+	assert.Equal(t, "150000.25", string(out))
+
+	_, err = p.ParseRounding("nearest_1000")
+	assert.NotNil(t, err)
+}
+
+func TestFunctionPromoCurrency(t *testing.T) {
+	usd := newQuotaPromo("USD10", 5)
+	usd.Percentage = null.Int{}
+	usd.Amount = p.NullDecimalFrom(p.NewDecimal(10))
+	usd.Currency = "USD"
+	rounded := newQuotaPromo("ROUND15", 5)
+	rounded.Percentage = null.IntFrom(15)
+
+	moneyService := p.NewService(p.NewRepository([]*p.Promotion{usd, rounded}))
+	room := &p.RoomRequest{Date: "2020-02-16 10:00:00", Price: p.NewDecimal(123450)}
+
+	_, err := moneyService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "USD10"})
+	assert.Equal(t, p.ErrCurrencyMismatch, err, "a USD amount can not discount an IDR price")
+
+	res, err := moneyService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "USD10", Currency: "usd"})
+	assert.Nil(t, err)
+	assert.Equal(t, "USD", res.Currency)
+	assert.Equal(t, p.NewDecimal(123440), res.FinalPrice)
+
+	res, err = moneyService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "ROUND15"})
+	assert.Nil(t, err)
+	saving, _ := p.ParseDecimal("18517.5")
+	assert.Equal(t, saving, res.PromoPrice)
+
+	roundedService := moneyService.WithRounding(p.RoundNearest100)
+	res, err = roundedService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "ROUND15"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(18500), res.PromoPrice)
+	assert.Equal(t, p.NewDecimal(104950), res.FinalPrice)
+
+	usdRoom := &p.RoomRequest{Date: "2020-02-16 10:00:00", Price: p.NewDecimal(150)}
+	res, err = roundedService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{usdRoom}, Code: "ROUND15", Currency: "USD"})
+	assert.Nil(t, err)
+	saving, _ = p.ParseDecimal("22.5")
+	assert.Equal(t, saving, res.PromoPrice, "a USD discount is rounded to the cent, not to the nearest 100")
+	assert.Equal(t, p.NewDecimal(150)-saving, res.FinalPrice)
+
+	_, err = moneyService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "ROUND15", Currency: "RUPIAH"})
+	assert.Equal(t, p.ErrInvalidCurrency, err)
+}
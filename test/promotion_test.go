@@ -1,15 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
 	h "github.com/chandrafortuna/simple-promotion-api/handler"
 	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/guregu/null.v3"
@@ -20,25 +24,27 @@ var (
 	start, _ = utils.ParseTimeFromString("2020-02-15 00:00:00")
 	end, _   = utils.ParseTimeFromString("2020-02-22 23:00:00")
 	promo    = &p.Promotion{
-		ID:          id,
-		Balance:     10,
-		Code:        "PROMOTEST123",
-		Title:       "Test Promo",
-		Percentage:  null.NewInt(10, true),
-		Qty:         20,
-		Redeem:      2,
-		StartDate:   null.NewTime(start, true),
-		EndDate:     null.NewTime(end, true),
-		Status:      int64(1),
-		MinNight:    null.NewInt(2, true),
-		MinRoom:     null.NewInt(2, true),
-		CheckinDays: null.NewString("Sunday", true),
+		ID:         id,
+		Balance:    10,
+		Code:       "PROMOTEST123",
+		Title:      "Test Promo",
+		Percentage: null.NewInt(10, true),
+		Qty:        20,
+		Redeem:     2,
+		StartDate:  null.NewTime(start, true),
+		EndDate:    null.NewTime(end, true),
+		Status:     p.StatusActive,
+		Rules: p.RuleList{
+			&p.MinNightRule{Min: 2},
+			&p.MinRoomRule{Min: 2},
+			&p.CheckinDayRule{Days: "Sunday"},
+		},
 	}
 
 	rooms1 = &p.RoomRequest{
 		Date:  "2020-02-16 10:00:00",
 		Night: null.NewInt(2, true),
-		Price: float64(150000),
+		Price: p.NewDecimal(150000),
 		Qty:   null.NewInt(2, true),
 		Room:  "Room1",
 	}
@@ -46,7 +52,7 @@ var (
 	rooms2 = &p.RoomRequest{
 		Date:  "2020-02-25 10:00:00",
 		Night: null.NewInt(1, true),
-		Price: float64(150000),
+		Price: p.NewDecimal(150000),
 		Qty:   null.NewInt(2, true),
 		Room:  "Room2",
 	}
@@ -54,7 +60,7 @@ var (
 	rooms3 = &p.RoomRequest{
 		Date:  "2020-02-17 10:00:00",
 		Night: null.NewInt(2, true),
-		Price: float64(150000),
+		Price: p.NewDecimal(150000),
 		Qty:   null.NewInt(2, true),
 		Room:  "Room3",
 	}
@@ -62,15 +68,14 @@ var (
 	rooms4 = &p.RoomRequest{
 		Date:  "2020-02-16 10:00:00",
 		Night: null.NewInt(2, true),
-		Price: float64(150000),
+		Price: p.NewDecimal(150000),
 		Qty:   null.NewInt(1, true),
 		Room:  "Room4",
 	}
 
 	applyPromoRequest = p.ApplyPromoRequest{
-		Rooms:      []*p.RoomRequest{rooms1, rooms2},
-		Code:       "PROMOTEST123",
-		TotalPrice: float64(150000),
+		Rooms: []*p.RoomRequest{rooms1, rooms2},
+		Code:  "PROMOTEST123",
 	}
 
 	repo          = p.NewRepository([]*p.Promotion{promo})
@@ -88,9 +93,8 @@ var (
 		"minRoom": 2,
 		"minNight": 1,
 		"checkinDays": "Sunday",
-		"bookingDays": "null",
-		"bookingHourStart": null,
-		"bookingHourEnd": null
+		"bookingDays": null,
+		"bookingWindows": null
 	}`
 
 	applyBody = `
@@ -172,10 +176,41 @@ func TestHTTPApplyPromo(t *testing.T) {
 	}
 }
 
-func TestFunctionApplyPromo(t *testing.T) {
-	res, err := service.ApplyPromotion(applyPromoRequest)
+func TestHTTPApplyPromoErrors(t *testing.T) {
+	idr := newQuotaPromo("HTTPIDR", 5)
+	idr.Stackable = true
+	idr.ExclusiveGroup = null.StringFrom("flash")
+	usd := newQuotaPromo("HTTPUSD", 5)
+	usd.Percentage = null.Int{}
+	usd.Amount = p.NullDecimalFrom(p.NewDecimal(10))
+	usd.Currency = "USD"
+	usd.Stackable = true
+	usd.ExclusiveGroup = null.StringFrom("flash")
+	errorHandler := h.NewHandler(p.NewService(p.NewRepository([]*p.Promotion{idr, usd})))
+
+	room := `{"date": "2020-02-16 10:00:00", "price": 150000, "night": %d}`
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"code": "UNKNOWN", "rooms": [` + fmt.Sprintf(room, 1) + `]}`, http.StatusNotFound},
+		{`{"code": "HTTPUSD", "rooms": [` + fmt.Sprintf(room, 1) + `]}`, http.StatusUnprocessableEntity},
+		{`{"codes": ["HTTPIDR", "HTTPUSD"], "rooms": [` + fmt.Sprintf(room, 1) + `]}`, http.StatusUnprocessableEntity},
+		{`{"code": "HTTPIDR", "rooms": [` + fmt.Sprintf(room, p.MaxNights+1) + `]}`, http.StatusBadRequest},
+		{`{"code": "HTTPIDR", "rooms": [{"date": "16-02-2020", "price": 150000}]}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("POST", "/promo/apply", strings.NewReader(c.body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(errorHandler.ApplyPromo).ServeHTTP(rr, req)
+		assert.Equal(t, c.status, rr.Code, c.body)
+	}
+}
 
-	fmt.Sprintf("res: %v", res)
+func TestFunctionApplyPromo(t *testing.T) {
+	bookedAt, _ := utils.ParseTimeFromString("2020-02-16 09:00:00")
+	clockService := service.WithClock(p.FixedClock(bookedAt))
+	res, err := clockService.ApplyPromotion(applyPromoRequest)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, res)
@@ -184,26 +219,603 @@ func TestFunctionApplyPromo(t *testing.T) {
 
 	for _, room := range res.Rooms {
 		if room.Room == "Room1" {
-			assert.Equal(t, room.Price, float64(150000))
-			assert.Equal(t, room.PromoPrice, (float64(150000) - float64(15000)))
+			assert.Equal(t, room.Price, p.NewDecimal(150000))
+			assert.Equal(t, room.PromoPrice, (p.NewDecimal(150000) - p.NewDecimal(15000)))
 			assert.Equal(t, room.Message, "")
 		}
 
 		if room.Room == "Room2" {
-			assert.Equal(t, room.Price, float64(150000))
-			assert.Equal(t, room.PromoPrice, float64(150000))
+			assert.Equal(t, room.Price, p.NewDecimal(150000))
+			assert.Equal(t, room.PromoPrice, p.NewDecimal(150000))
 			assert.Contains(t, room.Message, "Min Night rule is failed")
 		}
 
 		if room.Room == "Room3" {
-			assert.Equal(t, room.Price, float64(150000))
-			assert.Equal(t, room.PromoPrice, float64(150000))
+			assert.Equal(t, room.Price, p.NewDecimal(150000))
+			assert.Equal(t, room.PromoPrice, p.NewDecimal(150000))
 			assert.Contains(t, room.Message, "checkin time rule is failed")
 		}
 		if room.Room == "Room4" {
-			assert.Equal(t, room.Price, float64(150000))
-			assert.Equal(t, room.PromoPrice, float64(150000))
+			assert.Equal(t, room.Price, p.NewDecimal(150000))
+			assert.Equal(t, room.PromoPrice, p.NewDecimal(150000))
 			assert.Equal(t, room.Message, "This promo apply min night")
 		}
 	}
 }
+
+func TestFunctionRedeemPromo(t *testing.T) {
+	redeemID, _ := uuid.NewV4()
+	redeemPromo := &p.Promotion{
+		ID:         redeemID,
+		Balance:    1,
+		Code:       "REDEEMTEST",
+		Title:      "Redeem Promo",
+		Percentage: null.NewInt(10, true),
+		Qty:        1,
+		Status:     p.StatusActive,
+	}
+	redeemRepo := p.NewRepository([]*p.Promotion{redeemPromo})
+	redeemService := p.NewService(redeemRepo)
+
+	req := p.RedeemPromoRequest{
+		ApplyPromoRequest: p.ApplyPromoRequest{
+			Rooms: []*p.RoomRequest{rooms1},
+			Code:  "REDEEMTEST",
+		},
+		BookingRef: "BOOKING-1",
+	}
+
+	res, err := redeemService.RedeemPromotion(req)
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	assert.Equal(t, "BOOKING-1", res.Redemptions[0].BookingRef)
+	assert.Equal(t, p.NewDecimal(15000), res.Result.PromoPrice)
+
+	redeemed, _ := redeemRepo.GetPromotionByID(redeemID)
+	assert.Equal(t, int64(1), redeemed.Redeem)
+	assert.Equal(t, int64(0), redeemed.Balance)
+
+	_, err = redeemService.RedeemPromotion(req)
+	assert.Equal(t, p.ErrBookingAlreadyRedeemed, err)
+
+	req.BookingRef = "BOOKING-2"
+	_, err = redeemService.RedeemPromotion(req)
+	assert.Equal(t, p.ErrPromoNotApplied, err)
+}
+
+func TestFunctionReservePromo(t *testing.T) {
+	reserveID, _ := uuid.NewV4()
+	reservePromo := &p.Promotion{
+		ID:         reserveID,
+		Balance:    1,
+		Code:       "RESERVETEST",
+		Title:      "Reserve Promo",
+		Percentage: null.NewInt(10, true),
+		Qty:        1,
+		Status:     p.StatusActive,
+	}
+	reserveRepo := p.NewRepository([]*p.Promotion{reservePromo})
+	reserveService := p.NewService(reserveRepo)
+
+	req := p.ReservePromoRequest{
+		RedeemPromoRequest: p.RedeemPromoRequest{
+			ApplyPromoRequest: p.ApplyPromoRequest{
+				Rooms: []*p.RoomRequest{rooms1},
+				Code:  "RESERVETEST",
+			},
+			BookingRef: "BOOKING-1",
+		},
+		TTL: 600,
+	}
+
+	res, err := reserveService.ReservePromotion(req)
+	assert.Nil(t, err)
+	assert.Equal(t, p.HoldStatusHeld, res.Holds[0].Status)
+	reserved, _ := reserveRepo.GetPromotionByID(reserveID)
+	assert.Equal(t, int64(0), reserved.Balance)
+
+	_, err = reserveService.ReservePromotion(req)
+	assert.Equal(t, p.ErrBookingAlreadyReserved, err)
+
+	holds, err := reserveService.CancelReservation("BOOKING-1")
+	assert.Nil(t, err)
+	assert.Equal(t, p.HoldStatusCancelled, holds[0].Status)
+	reserved, _ = reserveRepo.GetPromotionByID(reserveID)
+	assert.Equal(t, int64(1), reserved.Balance)
+
+	req.BookingRef = "BOOKING-2"
+	_, err = reserveService.ReservePromotion(req)
+	assert.Nil(t, err)
+
+	confirmed, err := reserveService.ConfirmReservation("BOOKING-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "BOOKING-2", confirmed.Redemptions[0].BookingRef)
+	reserved, _ = reserveRepo.GetPromotionByID(reserveID)
+	assert.Equal(t, int64(1), reserved.Redeem)
+	assert.Equal(t, int64(0), reserved.Balance)
+
+	_, err = reserveService.ConfirmReservation("BOOKING-2")
+	assert.Equal(t, p.ErrHoldNotFound, err)
+
+	req.TTL = -1
+	_, err = reserveService.ReservePromotion(req)
+	assert.Equal(t, p.ErrInvalidHoldTTL, err)
+}
+
+func TestFunctionConfirmExpiredReservation(t *testing.T) {
+	first := newQuotaPromo("EXPIREDFIRST", 1)
+	second := newQuotaPromo("EXPIREDSECOND", 1)
+	repo := p.NewRepository([]*p.Promotion{first, second})
+	heldAt := time.Date(2020, 3, 10, 10, 0, 0, 0, time.UTC)
+	service := p.NewService(repo).WithClock(p.FixedClock(heldAt.Add(time.Hour)))
+
+	ttls := map[*p.Promotion]time.Duration{first: time.Minute, second: 2 * time.Hour}
+	for _, promo := range []*p.Promotion{first, second} {
+		read, _ := repo.GetPromotionByID(promo.ID)
+		read.Balance--
+		read.Distribution.Balance--
+		assert.Nil(t, repo.Update(read))
+
+		holdID, _ := uuid.NewV4()
+		assert.Nil(t, repo.SaveHold(&p.Hold{ID: holdID, PromoID: promo.ID, Code: promo.Code, BookingRef: "BOOKING-EXPIRED",
+			Status: p.HoldStatusHeld, CreatedAt: heldAt, ExpiresAt: heldAt.Add(ttls[promo])}))
+	}
+
+	_, err := service.ConfirmReservation("BOOKING-EXPIRED")
+	assert.Equal(t, p.ErrHoldExpired, err)
+
+	holds, _ := repo.GetHoldsByBookingRef("BOOKING-EXPIRED")
+	for _, hold := range holds {
+		assert.Equal(t, p.HoldStatusExpired, hold.Status, hold.Code)
+		released, _ := repo.GetPromotionByID(hold.PromoID)
+		assert.Equal(t, int64(1), released.Balance, hold.Code)
+	}
+
+	_, err = service.ConfirmReservation("BOOKING-EXPIRED")
+	assert.Equal(t, p.ErrHoldNotFound, err, "the live hold is not confirmed without the expired one")
+	redemptions, _ := repo.GetRedemptionsByBookingRef("BOOKING-EXPIRED")
+	assert.Len(t, redemptions, 0)
+}
+
+func TestHTTPPatchAndDeletePromo(t *testing.T) {
+	editPromo := newQuotaPromo("EDITTEST", 10)
+	editPromo.Redeem = 3
+	editPromo.Balance = 7
+	editRepo := p.NewRepository([]*p.Promotion{editPromo})
+	editHandler := h.NewHandler(p.NewService(editRepo))
+	vars := map[string]string{"id": editPromo.ID.String()}
+
+	req, err := http.NewRequest("PATCH", "/promo/"+editPromo.ID.String(), strings.NewReader(`{"title": "Edited", "quota": 20}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(editHandler.PatchPromo).ServeHTTP(rr, mux.SetURLVars(req, vars))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	edited, _ := editRepo.GetPromotionByID(editPromo.ID)
+	assert.Equal(t, "Edited", edited.Title)
+	assert.Equal(t, int64(10), edited.Percentage.Int64)
+	assert.Equal(t, int64(20), edited.Qty)
+	assert.Equal(t, int64(3), edited.Redeem)
+	assert.Equal(t, int64(17), edited.Balance)
+
+	req, _ = http.NewRequest("PATCH", "/promo/"+editPromo.ID.String(), strings.NewReader(`{"title": "Stale", "version": 0}`))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(editHandler.PatchPromo).ServeHTTP(rr, mux.SetURLVars(req, vars))
+	assert.Equal(t, http.StatusConflict, rr.Code, "an edit made from an older version is not saved")
+	edited, _ = editRepo.GetPromotionByID(editPromo.ID)
+	assert.Equal(t, "Edited", edited.Title)
+
+	edited.BlackoutDates = p.DateList{"2020-02-16"}
+	patch := p.NewPromoRequest(edited)
+	patch.BlackoutDates[0] = "2020-02-17"
+	assert.Equal(t, "2020-02-16", edited.BlackoutDates[0], "the request does not share the blackout dates of the promo")
+
+	req, _ = http.NewRequest("PUT", "/promo/"+editPromo.ID.String(), strings.NewReader(`{"title": "Replaced", "code": "EDITTEST", "amount": 5000, "quota": 2}`))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(editHandler.UpdatePromo).ServeHTTP(rr, mux.SetURLVars(req, vars))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "quota lower than redeemed must be rejected")
+
+	req, _ = http.NewRequest("DELETE", "/promo/"+editPromo.ID.String(), nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(editHandler.DeletePromo).ServeHTTP(rr, mux.SetURLVars(req, vars))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	deleted, _ := editRepo.GetPromotionByCode("EDITTEST")
+	assert.Equal(t, p.StatusArchived, deleted.Status)
+	assert.Equal(t, int64(17), deleted.Balance)
+}
+
+func TestHTTPInvalidPromoConfig(t *testing.T) {
+	configPromo := newQuotaPromo("CONFIGTEST", 10)
+	configHandler := h.NewHandler(p.NewService(p.NewRepository([]*p.Promotion{configPromo})))
+	vars := map[string]string{"id": configPromo.ID.String()}
+
+	bodies := map[string]string{
+		"distribution strategy": `{"title": "Config", "code": "CONFIGTEST", "percentage": 10, "quota": 10, "distributionStrategy": "random"}`,
+		"slot size":             `{"title": "Config", "code": "CONFIGTEST", "percentage": 10, "quota": 10, "slots": {"size": "day", "qty": 1}}`,
+		"slot quota":            `{"title": "Config", "code": "CONFIGTEST", "percentage": 10, "quota": 10, "slots": {"size": "hour", "qty": 0}}`,
+		"guest limit":           `{"title": "Config", "code": "CONFIGTEST", "percentage": 10, "quota": 10, "guestLimit": 0}`,
+		"guest daily limit":     `{"title": "Config", "code": "CONFIGTEST", "percentage": 10, "quota": 10, "guestDailyLimit": -1}`,
+	}
+	for name, body := range bodies {
+		req, _ := http.NewRequest("POST", "/promo", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(configHandler.CreatePromo).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "create with an invalid %s", name)
+
+		req, _ = http.NewRequest("PUT", "/promo/"+configPromo.ID.String(), strings.NewReader(body))
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(configHandler.UpdatePromo).ServeHTTP(rr, mux.SetURLVars(req, vars))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "update with an invalid %s", name)
+
+		req, _ = http.NewRequest("PATCH", "/promo/"+configPromo.ID.String(), strings.NewReader(body))
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(configHandler.PatchPromo).ServeHTTP(rr, mux.SetURLVars(req, vars))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "patch with an invalid %s", name)
+	}
+}
+
+func TestFunctionPromoRequestApplyTo(t *testing.T) {
+	invalid := map[string]p.PromoRequest{
+		"discount":   {Code: "APPLYTO", Quota: 10},
+		"date range": {Code: "APPLYTO", Percentage: null.IntFrom(10), Quota: 10, EndDate: null.StringFrom("2020-02-16 00:00:00")},
+		"stay range": {Code: "APPLYTO", Percentage: null.IntFrom(10), Quota: 10, StayStart: null.StringFrom("2020-02-16"), StayEnd: null.StringFrom("2020-02-13")},
+		"strategy":   {Code: "APPLYTO", Percentage: null.IntFrom(10), Quota: 10, Strategy: "random"},
+		"guest":      {Code: "APPLYTO", Percentage: null.IntFrom(10), Quota: 10, GuestLimit: null.IntFrom(0)},
+	}
+	for name, req := range invalid {
+		promo := newQuotaPromo("APPLYTO", 10)
+		assert.NotNil(t, req.Validate(), "validate an invalid %s", name)
+		assert.Equal(t, req.Validate(), req.ApplyTo(promo), "update with an invalid %s", name)
+		assert.Equal(t, "APPLYTO", promo.Code)
+		assert.Equal(t, int64(10), promo.Qty)
+	}
+
+	req := p.PromoRequest{
+		Code:       "APPLYTO",
+		Percentage: null.IntFrom(10),
+		Quota:      10,
+		Timezone:   "Asia/Jakarta",
+		StartDate:  null.StringFrom("2020-02-13 00:00:00"),
+		EndDate:    null.StringFrom("2020-02-16 00:00:00"),
+		StayStart:  null.StringFrom("2020-02-13"),
+		StayEnd:    null.StringFrom("2020-02-20"),
+	}
+	promo := newQuotaPromo("APPLYTO", 10)
+	assert.Nil(t, req.ApplyTo(promo))
+	assert.Equal(t, "2020-02-12T17:00:00Z", promo.StartDate.Time.UTC().Format(time.RFC3339))
+	assert.Equal(t, "2020-02-16T16:59:59Z", promo.EndDate.Time.UTC().Format(time.RFC3339))
+	assert.Equal(t, "2020-02-20", promo.StayEnd.Time.Format(utils.DateLayout))
+}
+
+func TestFunctionPromoLifecycle(t *testing.T) {
+	lifecyclePromo := newQuotaPromo("LIFECYCLE", 1)
+	lifecycleRepo := p.NewRepository([]*p.Promotion{lifecyclePromo})
+	lifecycleService := p.NewService(lifecycleRepo)
+
+	paused, err := lifecycleService.PausePromotion(lifecyclePromo.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, p.StatusPaused, paused.Status)
+
+	available, _ := lifecycleService.GetAvailablePromo()
+	for _, promo := range available {
+		assert.NotEqual(t, "LIFECYCLE", promo.Code, "paused promo must not be available")
+	}
+
+	_, err = lifecycleService.PublishPromotion(lifecyclePromo.ID)
+	assert.Equal(t, p.ErrInvalidStatusTransition, err)
+
+	resumed, err := lifecycleService.ResumePromotion(lifecyclePromo.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, p.StatusActive, resumed.Status)
+
+	_, err = lifecycleService.RedeemPromotion(p.RedeemPromoRequest{
+		ApplyPromoRequest: p.ApplyPromoRequest{
+			Rooms: []*p.RoomRequest{rooms1},
+			Code:  "LIFECYCLE",
+		},
+		BookingRef: "BOOKING-1",
+	})
+	assert.Nil(t, err)
+
+	exhausted, _ := lifecycleService.GetPromotion(lifecyclePromo.ID)
+	assert.Equal(t, p.StatusExhausted, exhausted.Status)
+
+	archived, err := lifecycleService.ArchivePromotion(lifecyclePromo.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, p.StatusArchived, archived.Status)
+
+	_, err = lifecycleService.ResumePromotion(lifecyclePromo.ID)
+	assert.Equal(t, p.ErrInvalidStatusTransition, err)
+}
+
+func TestFunctionStackPromo(t *testing.T) {
+	member := newQuotaPromo("MEMBER10", 5)
+	member.Stackable = true
+	member.ExclusiveGroup = null.StringFrom("member")
+	seasonal := newQuotaPromo("SEASON5000", 5)
+	seasonal.Percentage = null.Int{}
+	seasonal.Amount = p.NullDecimalFrom(p.NewDecimal(5000))
+	seasonal.Stackable = true
+	vip := newQuotaPromo("VIP15", 5)
+	vip.Stackable = true
+	vip.ExclusiveGroup = null.StringFrom("member")
+	solo := newQuotaPromo("SOLO", 5)
+
+	stackRepo := p.NewRepository([]*p.Promotion{member, seasonal, vip, solo})
+	stackService := p.NewService(stackRepo)
+	req := p.ApplyPromoRequest{
+		Rooms: []*p.RoomRequest{rooms1},
+		Codes: []string{"SEASON5000", "MEMBER10"},
+	}
+
+	res, err := stackService.ApplyPromotion(req)
+	assert.Nil(t, err)
+	room := res.Rooms[0]
+	assert.Equal(t, p.NewDecimal(130000), room.PromoPrice)
+	assert.Equal(t, "MEMBER10", room.Promos[0].Code, "percentage promo is applied first")
+	assert.Equal(t, p.NewDecimal(15000), room.Promos[0].Saving)
+	assert.Equal(t, p.NewDecimal(5000), room.Promos[1].Saving)
+
+	amountFirst := p.NewService(stackRepo).WithStackOrder(p.StackOrderAmountFirst)
+	res, err = amountFirst.ApplyPromotion(req)
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(130500), res.Rooms[0].PromoPrice)
+	assert.Equal(t, "SEASON5000", res.Rooms[0].Promos[0].Code)
+
+	_, err = stackService.ApplyPromotion(p.ApplyPromoRequest{Rooms: req.Rooms, Codes: []string{"MEMBER10", "VIP15"}})
+	assert.IsType(t, &p.StackError{}, err)
+
+	_, err = stackService.ApplyPromotion(p.ApplyPromoRequest{Rooms: req.Rooms, Codes: []string{"MEMBER10", "SOLO"}})
+	assert.IsType(t, &p.StackError{}, err)
+
+	redeemed, err := stackService.RedeemPromotion(p.RedeemPromoRequest{ApplyPromoRequest: req, BookingRef: "BOOKING-1"})
+	assert.Nil(t, err)
+	assert.Len(t, redeemed.Redemptions, 2)
+	for _, promo := range []*p.Promotion{member, seasonal} {
+		updated, _ := stackRepo.GetPromotionByID(promo.ID)
+		assert.Equal(t, int64(4), updated.Balance)
+	}
+}
+
+// unavailableRepository fails to list the available promos, like a database which is down
+type unavailableRepository struct {
+	p.Repository
+}
+
+func (unavailableRepository) GetAllAvailable() ([]*p.Promotion, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHTTPBestPromoErrors(t *testing.T) {
+	repo := p.NewRepository([]*p.Promotion{newQuotaPromo("HTTPBEST", 5)})
+	cases := []struct {
+		repo   p.Repository
+		body   string
+		status int
+	}{
+		{repo, `{"currency": "RUPIAH", "rooms": [{"date": "2020-02-16 10:00:00", "price": 150000}]}`, http.StatusBadRequest},
+		{unavailableRepository{repo}, `{"rooms": [{"date": "2020-02-16 10:00:00", "price": 150000}]}`, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("POST", "/promo/best", strings.NewReader(c.body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.NewHandler(p.NewService(c.repo)).BestPromo).ServeHTTP(rr, req)
+		assert.Equal(t, c.status, rr.Code, c.body)
+	}
+}
+
+func TestFunctionBestPromo(t *testing.T) {
+	member := newQuotaPromo("MEMBER10", 5)
+	member.Stackable = true
+	member.ExclusiveGroup = null.StringFrom("member")
+	member.Public = true
+	vip := newQuotaPromo("VIP20", 5)
+	vip.Percentage = null.IntFrom(20)
+	vip.Stackable = true
+	vip.ExclusiveGroup = null.StringFrom("member")
+	vip.Public = true
+	seasonal := newQuotaPromo("SEASON5000", 5)
+	seasonal.Percentage = null.Int{}
+	seasonal.Amount = p.NullDecimalFrom(p.NewDecimal(5000))
+	seasonal.Stackable = true
+	seasonal.AutoApply = true
+	solo := newQuotaPromo("SOLO20", 5)
+	solo.Percentage = null.IntFrom(20)
+	solo.Public = true
+	longStay := newQuotaPromo("LONGSTAY", 5)
+	longStay.Rules = p.RuleList{&p.MinNightRule{Min: 5}}
+	longStay.Public = true
+	hidden := newQuotaPromo("HIDDEN50", 5)
+	hidden.Percentage = null.IntFrom(50)
+
+	bestService := p.NewService(p.NewRepository([]*p.Promotion{member, vip, seasonal, solo, longStay, hidden}))
+	res, err := bestService.BestPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{rooms1}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"VIP20", "SEASON5000"}, res.Codes)
+	assert.Equal(t, p.NewDecimal(35000), res.Result.PromoPrice)
+	assert.Equal(t, p.NewDecimal(115000), res.Result.FinalPrice)
+
+	rejected := map[string]string{}
+	for _, r := range res.Rejected {
+		rejected[r.Code] = r.Reason
+	}
+	assert.Len(t, rejected, 3, "promos which are neither public nor auto apply are never considered")
+	assert.Contains(t, rejected, "MEMBER10")
+	assert.Contains(t, rejected, "SOLO20")
+	assert.Equal(t, "Min Night rule is failed", rejected["LONGSTAY"])
+
+	res, err = bestService.BestPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{}})
+	assert.Nil(t, err)
+	assert.Empty(t, res.Codes)
+}
+
+func TestFunctionPromoLimits(t *testing.T) {
+	capped := newQuotaPromo("CAPPED50", 5)
+	capped.Percentage = null.IntFrom(50)
+	capped.MaxDiscount = p.NullDecimalFrom(p.NewDecimal(20000))
+	floor := newQuotaPromo("FLOOR", 5)
+	floor.Percentage = null.Int{}
+	floor.Amount = p.NullDecimalFrom(p.NewDecimal(100000))
+	floor.MinPrice = p.NullDecimalFrom(p.NewDecimal(80000))
+	huge := newQuotaPromo("HUGE", 5)
+	huge.Percentage = null.Int{}
+	huge.Amount = p.NullDecimalFrom(p.NewDecimal(500000))
+	spend := newQuotaPromo("SPEND", 5)
+	spend.MinSpend = p.NullDecimalFrom(p.NewDecimal(200000))
+
+	limitService := p.NewService(p.NewRepository([]*p.Promotion{capped, floor, huge, spend}))
+	apply := func(code string) *p.ApplyPromoResponse {
+		res, err := limitService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{rooms1}, Code: code})
+		assert.Nil(t, err)
+		return res
+	}
+
+	assert.Equal(t, p.NewDecimal(20000), apply("CAPPED50").PromoPrice, "discount is capped by max discount")
+	assert.Equal(t, p.NewDecimal(80000), apply("FLOOR").FinalPrice, "price never goes below min price")
+	assert.Equal(t, p.NewDecimal(0), apply("HUGE").FinalPrice, "price never goes below zero")
+
+	res := apply("SPEND")
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice)
+	assert.Contains(t, res.Rooms[0].Message, "Min Spend rule is failed")
+
+	req := p.PromoRequest{Code: "INVALID", Percentage: null.IntFrom(120)}
+	assert.NotNil(t, req.Validate(), "percentage above 100 is rejected")
+	req.Percentage = null.IntFrom(-5)
+	assert.NotNil(t, req.Validate(), "negative percentage is rejected")
+	req.Percentage = null.IntFrom(100)
+	assert.Nil(t, req.Validate())
+	req.MaxDiscount = p.NullDecimalFrom(p.NewDecimal(-1))
+	assert.NotNil(t, req.Validate(), "negative max discount is rejected")
+}
+
+func TestFunctionOrderPromo(t *testing.T) {
+	basket := newQuotaPromo("BASKET10", 5)
+	basket.Scope = p.ScopeOrder
+	basket.MinSpend = p.NullDecimalFrom(p.NewDecimal(250000))
+	split := newQuotaPromo("SPLIT100", 5)
+	split.Scope = p.ScopeOrder
+	split.Percentage = null.Int{}
+	split.Amount = p.NullDecimalFrom(p.NewDecimal(100))
+
+	orderService := p.NewService(p.NewRepository([]*p.Promotion{basket, split}))
+	small := &p.RoomRequest{Date: "2020-02-16 10:00:00", Room: "Small", Price: p.NewDecimal(100000)}
+	large := &p.RoomRequest{Date: "2020-02-16 10:00:00", Room: "Large", Price: p.NewDecimal(200000)}
+
+	res, err := orderService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{small, large}, Code: "BASKET10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(300000), res.Order.TotalPrice)
+	assert.Equal(t, p.NewDecimal(30000), res.Order.PromoPrice)
+	assert.Equal(t, p.NewDecimal(10000), res.Rooms[0].Saving, "the order discount is split in proportion to the room price")
+	assert.Equal(t, p.NewDecimal(20000), res.Rooms[1].Saving)
+	assert.Equal(t, p.NewDecimal(270000), res.FinalPrice)
+
+	res, err = orderService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{small}, Code: "BASKET10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice)
+	assert.Contains(t, res.Order.Message, "Min Spend rule is failed")
+
+	var inflated p.ApplyPromoRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{"code": "BASKET10", "totalPrice": 100000000,
+		"rooms": [{"date": "2020-02-16 10:00:00", "price": 100000}]}`), &inflated))
+	res, err = orderService.ApplyPromotion(inflated)
+	assert.Nil(t, err)
+	assert.Contains(t, res.Order.Message, "Min Spend rule is failed", "an inflated total price does not pass the min spend")
+	assert.Equal(t, p.NewDecimal(100000), res.FinalPrice)
+	res, err = orderService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{small, small, small}, Code: "SPLIT100"})
+	assert.Nil(t, err)
+	sum := p.Decimal(0)
+	for _, room := range res.Rooms {
+		sum += room.Saving
+		assert.Equal(t, "SPLIT100", room.Promos[0].Code)
+	}
+	assert.Equal(t, p.NewDecimal(100), sum, "allocated shares add up to the order discount")
+	assert.Equal(t, "33.34", res.Rooms[0].Saving.String())
+}
+
+func TestFunctionOrderPromoEligibleRooms(t *testing.T) {
+	nights := newQuotaPromo("NIGHTS10", 5)
+	nights.Scope = p.ScopeOrder
+	nights.Rules = p.RuleList{&p.MinNightRule{Min: 2}}
+	spend := newQuotaPromo("SPEND10", 5)
+	spend.Scope = p.ScopeOrder
+	spend.Rules = p.RuleList{&p.MinNightRule{Min: 2}}
+	spend.MinSpend = p.NullDecimalFrom(p.NewDecimal(250000))
+	tiny := newQuotaPromo("TINY10", 5)
+	tiny.Scope = p.ScopeOrder
+	repo := p.NewRepository([]*p.Promotion{nights, spend, tiny})
+	orderService := p.NewService(repo).WithRounding(p.RoundNearest100)
+
+	short := &p.RoomRequest{Date: "2020-02-16 10:00:00", Room: "Short", Price: p.NewDecimal(100000), Night: null.IntFrom(1)}
+	long := &p.RoomRequest{Date: "2020-02-16 10:00:00", Room: "Long", Price: p.NewDecimal(200000), Night: null.IntFrom(2)}
+
+	res, err := orderService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{short, long}, Code: "NIGHTS10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(20000), res.Order.PromoPrice, "only the rooms the rules accept are discounted")
+	assert.Equal(t, p.NewDecimal(0), res.Rooms[0].Saving)
+	assert.Equal(t, p.NewDecimal(20000), res.Rooms[1].Saving)
+
+	res, err = orderService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{short, long}, Code: "SPEND10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice)
+	assert.Contains(t, res.Order.Message, "Min Spend rule is failed", "a room the rules reject does not count to the min spend")
+
+	cheap := &p.RoomRequest{Date: "2020-02-16 10:00:00", Room: "Cheap", Price: p.NewDecimal(400)}
+	res, err = orderService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{cheap}, Code: "TINY10"})
+	assert.Nil(t, err)
+	assert.Len(t, res.Rooms[0].Promos, 0, "a discount rounded to nothing is not a use of the promo")
+
+	_, err = orderService.RedeemPromotion(p.RedeemPromoRequest{
+		ApplyPromoRequest: p.ApplyPromoRequest{Rooms: []*p.RoomRequest{cheap}, Code: "TINY10"},
+		BookingRef:        "BOOKING-TINY",
+	})
+	assert.Equal(t, p.ErrPromoNotApplied, err)
+	saved, _ := repo.GetPromotionByCode("TINY10")
+	assert.Equal(t, int64(5), saved.Balance)
+}
+
+func TestFunctionStayNights(t *testing.T) {
+	stayStart, _ := utils.ParseDate("2020-02-01")
+	stayEnd, _ := utils.ParseDate("2020-02-29")
+	stay := newQuotaPromo("STAY10", 5)
+	stay.StayStart = null.TimeFrom(stayStart)
+	stay.StayEnd = null.TimeFrom(stayEnd)
+	stay.StayDays = null.StringFrom("weekend")
+	stay.BlackoutDates = p.DateList{"2020-02-16"}
+	stayService := p.NewService(p.NewRepository([]*p.Promotion{stay}))
+
+	friday := &p.RoomRequest{Date: "2020-02-14 14:00:00", Room: "Friday", Price: p.NewDecimal(300000), Night: null.IntFrom(3)}
+	res, err := stayService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{friday}, Code: "STAY10"})
+	assert.Nil(t, err)
+	room := res.Rooms[0]
+	assert.Len(t, room.Nights, 3)
+	assert.Equal(t, p.NewDecimal(10000), room.Saving, "only saturday is a weekend night outside the blackout")
+	assert.Equal(t, p.NewDecimal(0), room.Nights[0].Saving)
+	assert.Equal(t, p.NewDecimal(10000), room.Nights[1].Saving)
+	assert.Equal(t, p.NewDecimal(90000), room.Nights[1].PromoPrice)
+	assert.Equal(t, p.NewDecimal(0), room.Nights[2].Saving)
+
+	march := &p.RoomRequest{Date: "2020-03-07 14:00:00", Room: "March", Price: p.NewDecimal(300000), Night: null.IntFrom(2)}
+	res, err = stayService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{march}, Code: "STAY10"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(0), res.PromoPrice)
+	assert.Equal(t, "Promo not applied: Stay dates rule is failed", res.Rooms[0].Message)
+
+	year := &p.RoomRequest{Date: "2020-03-07 14:00:00", Room: "Year", Price: p.NewDecimal(365000), Night: null.IntFrom(p.MaxNights)}
+	res, err = stayService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{year}, Code: "STAY10"})
+	assert.Nil(t, err)
+	assert.Len(t, res.Rooms[0].Nights, p.MaxNights)
+
+	year.Night = null.IntFrom(1 << 40)
+	_, err = stayService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{year}, Code: "STAY10"})
+	assert.Equal(t, p.ErrInvalidNight, err, "a stay longer than the max nights is rejected before the nights are made")
+
+	req := p.PromoRequest{Code: "STAYINVALID", Percentage: null.IntFrom(10), BlackoutDates: p.DateList{"2020-02-30"}}
+	assert.NotNil(t, req.Validate())
+	req.BlackoutDates = nil
+	req.StayStart = null.StringFrom("2020-03-01")
+	req.StayEnd = null.StringFrom("2020-02-01")
+	assert.NotNil(t, req.Validate())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func newQuotaPromo(code string, balance int64) *p.Promotion {
+	promoID, _ := uuid.NewV4()
+	return &p.Promotion{
+		ID:         promoID,
+		Code:       code,
+		Title:      code,
+		Percentage: null.NewInt(10, true),
+		Qty:        balance,
+		Balance:    balance,
+		Status:     p.StatusActive,
+		Distribution: &p.PromoDistribution{
+			PromoID: promoID,
+			Qty:     balance,
+			Balance: balance,
+		},
+	}
+}
+
+func TestTempRepositoryUpdate(t *testing.T) {
+	quotaPromo := newQuotaPromo("REPOUPDATE", 5)
+	repo := p.NewRepository([]*p.Promotion{quotaPromo})
+
+	read, err := repo.GetPromotionByID(quotaPromo.ID)
+	assert.Nil(t, err)
+	read.Balance = 4
+	read.Distribution.Balance = 4
+
+	unchanged, _ := repo.GetPromotionByID(quotaPromo.ID)
+	assert.Equal(t, int64(5), unchanged.Balance, "changes to a read copy must not leak into the repository")
+	assert.Equal(t, int64(5), unchanged.Distribution.Balance)
+
+	assert.Nil(t, repo.Update(read))
+	assert.Equal(t, int64(1), read.Version)
+
+	updated, _ := repo.GetPromotionByCode("REPOUPDATE")
+	assert.Equal(t, int64(4), updated.Balance)
+	assert.Equal(t, int64(4), updated.Distribution.Balance)
+
+	assert.Equal(t, p.ErrPromoVersionConflict, repo.Update(unchanged))
+	assert.Equal(t, p.ErrDuplicatePromoCode, repo.Save(newQuotaPromo("REPOUPDATE", 1)))
+}
+
+func TestTempRepositoryConcurrentUpdate(t *testing.T) {
+	quotaPromo := newQuotaPromo("REPORACE", 1)
+	repo := p.NewRepository([]*p.Promotion{quotaPromo})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			read, err := repo.GetPromotionByID(quotaPromo.ID)
+			if err != nil || read.Balance < 1 {
+				return
+			}
+			read.Balance--
+			if repo.Update(read) == nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, taken)
+	updated, _ := repo.GetPromotionByID(quotaPromo.ID)
+	assert.Equal(t, int64(0), updated.Balance)
+}
+
+func TestConcurrentRedeemLastUnit(t *testing.T) {
+	quotaPromo := newQuotaPromo("REDEEMRACE", 1)
+	repo := p.NewRepository([]*p.Promotion{quotaPromo})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// a service per goroutine behaves like separate instances sharing the repository
+			instance := p.NewService(repo)
+			_, err := instance.RedeemPromotion(p.RedeemPromoRequest{
+				ApplyPromoRequest: p.ApplyPromoRequest{
+					Rooms: []*p.RoomRequest{rooms1},
+					Code:  "REDEEMRACE",
+				},
+				BookingRef: fmt.Sprintf("BOOKING-%d", i),
+			})
+			if err == nil {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, redeemed)
+	updated, _ := repo.GetPromotionByID(quotaPromo.ID)
+	assert.Equal(t, int64(1), updated.Redeem)
+	assert.Equal(t, int64(0), updated.Balance)
+	assert.Equal(t, int64(0), updated.Distribution.Balance)
+}
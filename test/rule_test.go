@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+// leadDaysRule asks the booking to be made some days before checkin, it is only known to the tests
+type leadDaysRule struct {
+	Days int `json:"days"`
+}
+
+func (r *leadDaysRule) Type() string {
+	return "lead_days"
+}
+
+func (r *leadDaysRule) Check(ctx *p.RuleContext) error {
+	if ctx.Checkin.Sub(ctx.BookingTime).Hours() < float64(r.Days*24) {
+		return &p.RuleFailure{Code: "LEAD_DAYS", Message: "Book earlier", Params: map[string]interface{}{"days": r.Days}}
+	}
+	return nil
+}
+
+func (r *leadDaysRule) Validate() error {
+	return nil
+}
+
+func init() {
+	p.RegisterRule("lead_days", func() p.Rule { return &leadDaysRule{} })
+}
+
+func TestRuleListJSON(t *testing.T) {
+	rules := p.RuleList{
+		&p.MinNightRule{Min: 2},
+		&p.CheckinDayRule{Days: "weekend"},
+		&p.BookingWindowRule{Windows: p.WindowList{{Start: "20:00", End: "22:00"}}},
+	}
+	b, err := json.Marshal(rules)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[
+		{"type": "min_night", "params": {"min": 2}},
+		{"type": "checkin_day", "params": {"days": "weekend"}},
+		{"type": "booking_window", "params": {"windows": [{"start": "20:00", "end": "22:00"}]}}
+	]`, string(b))
+
+	var decoded p.RuleList
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, rules, decoded)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`[{"type": "unknown", "params": {}}]`), &decoded))
+	assert.NotNil(t, json.Unmarshal([]byte(`[{"type": "min_night", "params": {"min": "two"}}]`), &decoded))
+	assert.Panics(t, func() {
+		p.RegisterRule("min_night", func() p.Rule { return &p.MinNightRule{} })
+	})
+}
+
+func TestFunctionRuleShorthands(t *testing.T) {
+	var req p.PromoRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"code": "RULES",
+		"percentage": 10,
+		"quota": 5,
+		"minNight": 3,
+		"rules": [
+			{"type": "min_night", "params": {"min": 2}},
+			{"type": "booking_day", "params": {"days": "weekday"}}
+		]
+	}`), &req))
+	assert.Nil(t, req.Validate())
+	promo, err := req.ToPromo(newQuotaPromo("RULES", 5).ID)
+	assert.Nil(t, err)
+	assert.Equal(t, p.RuleList{&p.MinNightRule{Min: 3}, &p.BookingDayRule{Days: "weekday"}}, promo.Rules)
+
+	req.Rules = p.RuleList{&p.MinNightRule{Min: -1}}
+	req.MinNight = null.Int{}
+	assert.NotNil(t, req.Validate())
+	req.Rules = p.RuleList{&p.CheckinDayRule{Days: "Someday"}}
+	assert.NotNil(t, req.Validate())
+}
+
+func TestFunctionCustomRule(t *testing.T) {
+	var req p.PromoRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"code": "EARLYBIRD",
+		"percentage": 10,
+		"quota": 5,
+		"rules": [{"type": "lead_days", "params": {"days": 7}}]
+	}`), &req))
+	assert.Nil(t, req.Validate())
+	promo, err := req.ToPromo(newQuotaPromo("EARLYBIRD", 5).ID)
+	assert.Nil(t, err)
+
+	bookedAt, _ := utils.ParseTimeFromString("2020-02-01 09:00:00")
+	service := p.NewService(p.NewRepository([]*p.Promotion{promo})).WithClock(p.FixedClock(bookedAt))
+	early := &p.RoomRequest{Date: "2020-02-16 14:00:00", Room: "Early", Price: p.NewDecimal(100000)}
+	late := &p.RoomRequest{Date: "2020-02-03 14:00:00", Room: "Late", Price: p.NewDecimal(100000)}
+	res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{early, late}, Code: "EARLYBIRD"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(10000), res.Rooms[0].Saving)
+	assert.Equal(t, p.NewDecimal(0), res.Rooms[1].Saving)
+	assert.Equal(t, []p.FailureCode{"LEAD_DAYS"}, failureCodes(res.Rooms[1].Failures))
+	assert.Equal(t, "EARLYBIRD", res.Rooms[1].Failures[0].PromoCode)
+}
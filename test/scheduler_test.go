@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := p.ParseSchedule("")
+	assert.Nil(t, err)
+	next := schedule.Next(time.Date(2020, 2, 16, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2020, 2, 17, 0, 0, 0, 0, time.UTC), next)
+
+	schedule, err = p.ParseSchedule("30 6 * * *")
+	assert.Nil(t, err)
+	next = schedule.Next(time.Date(2020, 2, 16, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2020, 2, 17, 6, 30, 0, 0, time.UTC), next)
+
+	_, err = p.ParseSchedule("every midnight")
+	assert.NotNil(t, err)
+}
+
+func TestFunctionScheduledDistribution(t *testing.T) {
+	now, _ := utils.ParseTimeFromString("2020-02-16 10:00:00")
+	clock := p.ClockFunc(func() time.Time { return now })
+	jakarta := newQuotaPromo("JAKARTA", 20)
+	jakarta.Timezone = "Asia/Jakarta"
+	jakarta.Distribution = nil
+	repo := p.NewRepository([]*p.Promotion{})
+	service := p.NewService(repo).WithClock(clock).WithInstance("first")
+	_, err := service.CreatePromotion(jakarta)
+	assert.Nil(t, err)
+
+	promo, _ := repo.GetPromotionByCode("JAKARTA")
+	midnight := time.Date(2020, 2, 16, 17, 0, 0, 0, time.UTC)
+	assert.True(t, promo.Distribution.NextRunAt.Time.Equal(midnight), "midnight in Jakarta")
+
+	promo.Distribution.Balance--
+	promo.Distribution.Redeem++
+	assert.Nil(t, repo.Update(promo))
+
+	now = midnight.Add(-time.Minute)
+	run, err := service.RunScheduledDistribution()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), run.Distributed)
+
+	now = midnight
+	run, err = service.RunScheduledDistribution()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), run.Distributed)
+	promo, _ = repo.GetPromotionByCode("JAKARTA")
+	assert.Equal(t, int64(0), promo.Distribution.Redeem)
+	assert.Equal(t, promo.Distribution.Qty, promo.Distribution.Balance)
+	assert.True(t, promo.Distribution.NextRunAt.Time.Equal(midnight.Add(24*time.Hour)))
+
+	// the service was down for three days, the missed distribution is made once
+	now = midnight.Add(3*24*time.Hour + 5*time.Hour)
+	run, err = service.RunScheduledDistribution()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), run.Distributed)
+	promo, _ = repo.GetPromotionByCode("JAKARTA")
+	assert.True(t, promo.Distribution.NextRunAt.Time.Equal(midnight.Add(4*24*time.Hour)))
+
+	run, err = service.RunScheduledDistribution()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), run.Distributed)
+
+	status, err := service.SchedulerStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", status.LastRun.Instance)
+	assert.True(t, status.LastRun.FinishedAt.Equal(now))
+	assert.True(t, status.NextRunAt.Time.Equal(midnight.Add(4*24*time.Hour)))
+}
+
+func TestFunctionSchedulerLock(t *testing.T) {
+	now, _ := utils.ParseTimeFromString("2020-02-16 10:00:00")
+	clock := p.ClockFunc(func() time.Time { return now })
+	repo := p.NewRepository([]*p.Promotion{newQuotaPromo("LOCKED", 10)})
+	first := p.NewService(repo).WithClock(clock).WithInstance("first")
+	second := p.NewService(repo).WithClock(clock).WithInstance("second")
+
+	locked, err := repo.AcquireLock(p.JobDistribution, "first", now, now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.True(t, locked)
+
+	_, err = second.RunScheduledDistribution()
+	assert.Equal(t, p.ErrJobLocked, err)
+	status, err := second.SchedulerStatus()
+	assert.Nil(t, err)
+	assert.Nil(t, status.LastRun)
+
+	run, err := first.RunScheduledDistribution()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), run.Distributed)
+
+	// the lock is released after the run, the promo is no longer due for the second instance
+	run, err = second.RunScheduledDistribution()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), run.Distributed)
+
+	// a lock left by a crashed instance expires
+	locked, _ = repo.AcquireLock(p.JobDistribution, "first", now, now.Add(time.Minute))
+	assert.True(t, locked)
+	now = now.Add(time.Minute)
+	_, err = second.RunScheduledDistribution()
+	assert.Nil(t, err)
+}
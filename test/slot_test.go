@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestFunctionFlashSaleSlots(t *testing.T) {
+	jakarta, _ := time.LoadLocation("Asia/Jakarta")
+	now := time.Date(2020, 2, 16, 20, 10, 0, 0, jakarta)
+	flash := newQuotaPromo("FLASH", 100)
+	flash.Timezone = "Asia/Jakarta"
+	flash.Slots = &p.SlotConfig{Size: p.SlotHour, Qty: 2, Windows: p.WindowList{{Start: "20:00", End: "23:00"}}}
+	repo := p.NewRepository([]*p.Promotion{flash})
+	service := p.NewService(repo).WithClock(p.ClockFunc(func() time.Time { return now }))
+
+	room := &p.RoomRequest{Date: "2020-02-20 14:00:00", Room: "Deluxe", Price: p.NewDecimal(100000), Night: null.IntFrom(1), Qty: null.IntFrom(1)}
+	redeem := func(ref string) error {
+		_, err := service.RedeemPromotion(p.RedeemPromoRequest{
+			ApplyPromoRequest: p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "FLASH"},
+			BookingRef:        ref,
+		})
+		return err
+	}
+	slotFailure := func() *p.RuleFailure {
+		res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "FLASH"})
+		assert.Nil(t, err)
+		if len(res.Rooms[0].Failures) == 0 {
+			return nil
+		}
+		assert.Equal(t, []p.FailureCode{p.FailureSlotExhausted}, failureCodes(res.Rooms[0].Failures))
+		return res.Rooms[0].Failures[0]
+	}
+
+	assert.Nil(t, redeem("BOOKING-1"))
+	assert.Nil(t, redeem("BOOKING-2"))
+	assert.NotNil(t, redeem("BOOKING-3"), "the 20:00 slot is exhausted")
+
+	failure := slotFailure()
+	assert.Equal(t, time.Date(2020, 2, 16, 21, 0, 0, 0, jakarta).UTC(), failure.Params["nextSlotAt"])
+	assert.Contains(t, failure.Message, "the next slot opens at 2020-02-16T21:00:00+07:00")
+
+	promo, _ := repo.GetPromotionByCode("FLASH")
+	assert.Equal(t, int64(98), promo.Distribution.Balance, "the daily quota is shared by the slots")
+	assert.Equal(t, int64(0), promo.Distribution.SlotBalance)
+
+	now = time.Date(2020, 2, 16, 21, 5, 0, 0, jakarta)
+	assert.Nil(t, slotFailure(), "the 21:00 slot has its own quota")
+	res, err := service.ReservePromotion(p.ReservePromoRequest{
+		RedeemPromoRequest: p.RedeemPromoRequest{
+			ApplyPromoRequest: p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "FLASH"},
+			BookingRef:        "BOOKING-4",
+		},
+		TTL: 600,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Holds))
+	promo, _ = repo.GetPromotionByCode("FLASH")
+	assert.Equal(t, int64(1), promo.Distribution.SlotBalance)
+
+	_, err = service.CancelReservation("BOOKING-4")
+	assert.Nil(t, err)
+	promo, _ = repo.GetPromotionByCode("FLASH")
+	assert.Equal(t, int64(2), promo.Distribution.SlotBalance, "a cancelled hold is returned to its slot")
+
+	now = time.Date(2020, 2, 16, 23, 30, 0, 0, jakarta)
+	failure = slotFailure()
+	assert.Equal(t, time.Date(2020, 2, 17, 20, 0, 0, 0, jakarta).UTC(), failure.Params["nextSlotAt"],
+		"no slot opens after 23:00, the next one is tomorrow")
+}
+
+func TestFunctionFlashSaleSlotsInvalid(t *testing.T) {
+	req := p.PromoRequest{Code: "FLASH", Percentage: null.IntFrom(10), Quota: 10, Slots: &p.SlotConfig{Size: "day", Qty: 5}}
+	_, err := req.ToPromo(newQuotaPromo("FLASH", 10).ID)
+	assert.NotNil(t, err)
+
+	req.Slots = &p.SlotConfig{Size: p.SlotQuarterHour}
+	_, err = req.ToPromo(newQuotaPromo("FLASH", 10).ID)
+	assert.NotNil(t, err)
+
+	req.Slots = &p.SlotConfig{Size: p.SlotQuarterHour, Qty: 5}
+	promo, err := req.ToPromo(newQuotaPromo("FLASH", 10).ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), promo.Slots.Qty)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	_ "github.com/mattn/go-sqlite3"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func newSQLiteRepository(t *testing.T) p.Repository {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	repo, err := p.NewSQLRepository(db, p.DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestSQLRepositoryPromotion(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	promoID, _ := uuid.NewV4()
+	sqlPromo := &p.Promotion{
+		ID:         promoID,
+		Title:      "SQL Promo",
+		Code:       "SQLTEST",
+		StartDate:  null.NewTime(start, true),
+		EndDate:    null.NewTime(end, true),
+		Percentage: null.NewInt(10, true),
+		Qty:        20,
+		Balance:    20,
+		Status:     p.StatusActive,
+		Rules:      p.RuleList{&p.CheckinDayRule{Days: "Sunday"}},
+		Distribution: &p.PromoDistribution{
+			PromoID: promoID,
+			Qty:     3,
+			Balance: 3,
+		},
+	}
+
+	assert.Nil(t, repo.Save(sqlPromo))
+	assert.NotNil(t, repo.Save(sqlPromo), "code must be unique")
+	assert.Equal(t, p.ErrDuplicatePromoCode, repo.Save(newQuotaPromo("SQLTEST", 1)))
+
+	exists, err := repo.ExistsByCode("SQLTEST")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	saved, err := repo.GetPromotionByCode("SQLTEST")
+	assert.Nil(t, err)
+	assert.Equal(t, promoID, saved.ID)
+	assert.True(t, saved.StartDate.Time.Equal(start))
+	assert.Equal(t, p.RuleList{&p.CheckinDayRule{Days: "Sunday"}}, saved.Rules)
+	assert.Equal(t, int64(3), saved.Distribution.Balance)
+
+	saved.Balance--
+	saved.Distribution.Balance--
+	assert.Nil(t, repo.Update(saved))
+
+	updated, err := repo.GetPromotionByID(promoID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(19), updated.Balance)
+	assert.Equal(t, int64(2), updated.Distribution.Balance)
+
+	available, err := repo.GetAllAvailable()
+	assert.Nil(t, err)
+	assert.Len(t, available, 1)
+
+	_, err = repo.GetPromotionByCode("MISSING")
+	assert.Equal(t, p.ErrPromoNotFound, err)
+}
+
+func TestSQLRepositoryHold(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	promoID, _ := uuid.NewV4()
+	assert.Nil(t, repo.Save(&p.Promotion{ID: promoID, Code: "SQLHOLD", Percentage: null.NewInt(10, true)}))
+
+	holdID, _ := uuid.NewV4()
+	now := time.Now()
+	hold := &p.Hold{
+		ID:         holdID,
+		PromoID:    promoID,
+		Code:       "SQLHOLD",
+		BookingRef: "BOOKING-1",
+		Status:     p.HoldStatusHeld,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Minute),
+	}
+	assert.Nil(t, repo.SaveHold(hold))
+
+	expired, err := repo.GetExpiredHolds(now)
+	assert.Nil(t, err)
+	assert.Len(t, expired, 0)
+
+	expired, err = repo.GetExpiredHolds(now.Add(2 * time.Minute))
+	assert.Nil(t, err)
+	assert.Len(t, expired, 1)
+
+	hold.Status = p.HoldStatusExpired
+	assert.Nil(t, repo.UpdateHold(hold))
+
+	holds, err := repo.GetHoldsByBookingRef("BOOKING-1")
+	assert.Nil(t, err)
+	assert.Equal(t, p.HoldStatusExpired, holds[0].Status)
+
+	hold.Status = p.HoldStatusConfirmed
+	assert.Equal(t, p.ErrHoldNotHeld, repo.UpdateHold(hold), "a settled hold is settled once")
+	holds, _ = repo.GetHoldsByBookingRef("BOOKING-1")
+	assert.Equal(t, p.HoldStatusExpired, holds[0].Status)
+
+	hold.ID, _ = uuid.NewV4()
+	assert.Equal(t, p.ErrHoldNotFound, repo.UpdateHold(hold))
+}
+
+func TestSQLRepositoryMoney(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	promoID, _ := uuid.NewV4()
+	amount, _ := p.ParseDecimal("12.35")
+	assert.Nil(t, repo.Save(&p.Promotion{
+		ID:            promoID,
+		Code:          "SQLUSD",
+		Amount:        p.NullDecimalFrom(amount),
+		Currency:      "USD",
+		MinSpend:      p.NullDecimalFrom(p.NewDecimal(50)),
+		StayDays:      null.StringFrom("weekend"),
+		BlackoutDates: p.DateList{"2020-12-25", "2020-12-31"},
+		Timezone:      "Asia/Jakarta",
+		Rules: p.RuleList{
+			&p.MinNightRule{Min: 2},
+			&p.BookingWindowRule{Windows: p.WindowList{{Days: "Friday", Start: "23:30", End: "00:30"}}},
+		},
+	}))
+
+	saved, err := repo.GetPromotionByID(promoID)
+	assert.Nil(t, err)
+	assert.Equal(t, amount, saved.Amount.Decimal)
+	assert.Equal(t, "USD", saved.Currency)
+	assert.Equal(t, p.NewDecimal(50), saved.MinSpend.Decimal)
+	assert.False(t, saved.MaxDiscount.Valid)
+	assert.Equal(t, p.DateList{"2020-12-25", "2020-12-31"}, saved.BlackoutDates)
+	assert.Equal(t, "Asia/Jakarta", saved.Timezone)
+	assert.Equal(t, p.RuleList{
+		&p.MinNightRule{Min: 2},
+		&p.BookingWindowRule{Windows: p.WindowList{{Days: "Friday", Start: "23:30", End: "00:30"}}},
+	}, saved.Rules)
+
+	redemptionID, _ := uuid.NewV4()
+	original, _ := p.ParseDecimal("100.10")
+	final, _ := p.ParseDecimal("87.75")
+	assert.Nil(t, repo.SaveRedemption(&p.Redemption{
+		ID:            redemptionID,
+		PromoID:       promoID,
+		Code:          "SQLUSD",
+		BookingRef:    "BOOKING-USD",
+		Currency:      "USD",
+		OriginalPrice: original,
+		PromoPrice:    amount,
+		FinalPrice:    final,
+		CreatedAt:     time.Now(),
+	}))
+
+	redemptions, err := repo.GetRedemptionsByBookingRef("BOOKING-USD")
+	assert.Nil(t, err)
+	assert.Equal(t, "USD", redemptions[0].Currency)
+	assert.Equal(t, original, redemptions[0].OriginalPrice)
+	assert.Equal(t, final, redemptions[0].FinalPrice)
+}
+
+func TestSQLRepositoryCalendar(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	calendarID, _ := uuid.NewV4()
+	calendar := &p.BlackoutCalendar{ID: calendarID, Name: "Holidays", Dates: []*p.CalendarDate{
+		{Date: "2020-12-25", Name: "Christmas"},
+		{Date: "2020-12-31", Name: "New Year Eve"},
+	}}
+	assert.Nil(t, repo.SaveCalendar(calendar))
+
+	promoID, _ := uuid.NewV4()
+	assert.Nil(t, repo.Save(&p.Promotion{ID: promoID, Code: "SQLCALENDAR", CalendarID: &calendarID}))
+	saved, err := repo.GetPromotionByID(promoID)
+	assert.Nil(t, err)
+	assert.Equal(t, calendarID, *saved.CalendarID)
+
+	read, err := repo.GetCalendarByID(calendarID)
+	assert.Nil(t, err)
+	assert.Equal(t, calendar, read)
+
+	calendar.Name = "Christmas"
+	calendar.Dates = calendar.Dates[:1]
+	assert.Nil(t, repo.UpdateCalendar(calendar))
+	calendars, err := repo.GetAllCalendars()
+	assert.Nil(t, err)
+	assert.Equal(t, []*p.BlackoutCalendar{calendar}, calendars)
+
+	emptyID, _ := uuid.NewV4()
+	assert.Nil(t, repo.SaveCalendar(&p.BlackoutCalendar{ID: emptyID, Name: "Empty", Dates: []*p.CalendarDate{}}))
+	assert.Nil(t, repo.DeleteCalendar(emptyID))
+	_, err = repo.GetCalendarByID(emptyID)
+	assert.Equal(t, p.ErrCalendarNotFound, err)
+	assert.Equal(t, p.ErrCalendarNotFound, repo.DeleteCalendar(emptyID))
+}
+
+func TestSQLRepositoryScheduler(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	now := time.Date(2020, 2, 16, 10, 0, 0, 0, time.UTC)
+
+	locked, err := repo.AcquireLock(p.JobDistribution, "first", now, now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.True(t, locked)
+	locked, err = repo.AcquireLock(p.JobDistribution, "second", now, now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, locked, "the lock of first has not expired")
+	locked, _ = repo.AcquireLock(p.JobDistribution, "first", now, now.Add(2*time.Minute))
+	assert.True(t, locked, "the owner extends its lock")
+	locked, _ = repo.AcquireLock(p.JobDistribution, "second", now.Add(2*time.Minute), now.Add(3*time.Minute))
+	assert.True(t, locked, "an expired lock is taken over")
+	assert.Nil(t, repo.ReleaseLock(p.JobDistribution, "second"))
+	locked, _ = repo.AcquireLock(p.JobDistribution, "first", now, now.Add(time.Minute))
+	assert.True(t, locked)
+
+	_, err = repo.GetJobRun(p.JobDistribution)
+	assert.Equal(t, p.ErrJobRunNotFound, err)
+	assert.Nil(t, repo.SaveJobRun(&p.JobRun{Job: p.JobDistribution, Instance: "first", StartedAt: now, FinishedAt: now, Distributed: 2}))
+	assert.Nil(t, repo.SaveJobRun(&p.JobRun{Job: p.JobDistribution, Instance: "second", StartedAt: now, FinishedAt: now, Error: "failed"}))
+	run, err := repo.GetJobRun(p.JobDistribution)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", run.Instance)
+	assert.Equal(t, "failed", run.Error)
+	assert.True(t, run.FinishedAt.Equal(now))
+
+	promo := newQuotaPromo("SQLSCHEDULE", 5)
+	promo.Distribution.DistributedAt = null.TimeFrom(now)
+	promo.Distribution.NextRunAt = null.TimeFrom(now.Add(14 * time.Hour))
+	assert.Nil(t, repo.Save(promo))
+	saved, err := repo.GetPromotionByCode("SQLSCHEDULE")
+	assert.Nil(t, err)
+	assert.True(t, saved.Distribution.DistributedAt.Time.Equal(now))
+	assert.True(t, saved.Distribution.NextRunAt.Time.Equal(now.Add(14*time.Hour)))
+}
+
+func TestSQLRepositoryDistributionLedger(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	promo := newQuotaPromo("SQLLEDGER", 10)
+	promo.Allocated = 3
+	promo.Distribution = &p.PromoDistribution{PromoID: promo.ID, Date: "2020-02-13", Qty: 3, Balance: 3, Allocated: 3}
+	assert.Nil(t, repo.Save(promo))
+
+	promo.Distribution.Balance = 2
+	promo.Distribution.Redeem = 1
+	assert.Nil(t, repo.Update(promo))
+
+	promo.Allocated = 6
+	promo.Distribution = &p.PromoDistribution{PromoID: promo.ID, Date: "2020-02-14", Qty: 5, Balance: 5, Carried: 2, Allocated: 3}
+	assert.Nil(t, repo.Update(promo))
+
+	saved, err := repo.GetPromotionByCode("SQLLEDGER")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), saved.Allocated)
+	assert.Equal(t, "2020-02-14", saved.Distribution.Date, "the promo holds the day distributed last")
+	assert.Equal(t, int64(2), saved.Distribution.Carried)
+
+	ledger, err := repo.GetDistributionLedger(promo.ID, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ledger))
+	assert.Equal(t, "2020-02-13", ledger[0].Date)
+	assert.Equal(t, int64(1), ledger[0].Redeem)
+	assert.Equal(t, int64(2), ledger[0].Balance)
+
+	ledger, err = repo.GetDistributionLedger(promo.ID, "2020-02-14", "2020-02-20")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ledger))
+	assert.Equal(t, int64(5), ledger[0].Qty)
+}
+
+func TestSQLRepositorySlots(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	slot := time.Date(2020, 2, 16, 13, 0, 0, 0, time.UTC)
+	promo := newQuotaPromo("SQLFLASH", 10)
+	promo.Slots = &p.SlotConfig{Size: p.SlotHour, Qty: 2, Windows: p.WindowList{{Start: "20:00", End: "23:00"}}}
+	promo.Distribution.Date = "2020-02-16"
+	promo.Distribution.SlotStart = null.TimeFrom(slot)
+	promo.Distribution.SlotBalance = 1
+	assert.Nil(t, repo.Save(promo))
+
+	saved, err := repo.GetPromotionByCode("SQLFLASH")
+	assert.Nil(t, err)
+	assert.Equal(t, promo.Slots, saved.Slots)
+	assert.True(t, saved.Distribution.SlotStart.Time.Equal(slot))
+	assert.Equal(t, int64(1), saved.Distribution.SlotBalance)
+
+	saved.Slots = nil
+	assert.Nil(t, repo.Update(saved))
+	saved, _ = repo.GetPromotionByCode("SQLFLASH")
+	assert.Nil(t, saved.Slots)
+}
+
+func TestSQLRepositoryGuest(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	promo := newQuotaPromo("SQLGUEST", 10)
+	promo.GuestLimit = null.IntFrom(2)
+	promo.DailyLimit = null.IntFrom(1)
+	promo.FirstBooking = true
+	assert.Nil(t, repo.Save(promo))
+
+	saved, err := repo.GetPromotionByCode("SQLGUEST")
+	assert.Nil(t, err)
+	assert.Equal(t, null.IntFrom(2), saved.GuestLimit)
+	assert.Equal(t, null.IntFrom(1), saved.DailyLimit)
+	assert.True(t, saved.FirstBooking)
+
+	now := time.Date(2020, 2, 16, 10, 0, 0, 0, time.UTC)
+	redemptionID, _ := uuid.NewV4()
+	assert.Nil(t, repo.SaveRedemption(&p.Redemption{ID: redemptionID, PromoID: promo.ID, Code: promo.Code, BookingRef: "BOOKING-1", Guest: "guest-1", CreatedAt: now}))
+	holdID, _ := uuid.NewV4()
+	assert.Nil(t, repo.SaveHold(&p.Hold{ID: holdID, PromoID: promo.ID, Code: promo.Code, BookingRef: "BOOKING-2", Guest: "guest-1",
+		Status: p.HoldStatusHeld, CreatedAt: now, ExpiresAt: now.Add(time.Minute)}))
+
+	redemptions, err := repo.GetRedemptionsByGuest("guest-1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(redemptions))
+	assert.Equal(t, "BOOKING-1", redemptions[0].BookingRef)
+	holds, err := repo.GetHoldsByGuest("guest-1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(holds))
+	assert.Equal(t, "guest-1", holds[0].Guest)
+
+	redemptions, _ = repo.GetRedemptionsByGuest("guest-2")
+	assert.Equal(t, 0, len(redemptions))
+
+	version, err := repo.GetGuestVersion("guest-1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), version)
+
+	read, _ := repo.GetPromotionByCode("SQLGUEST")
+	read.Balance--
+	assert.Nil(t, repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Guest: "guest-1", GuestVersion: version}))
+	version, _ = repo.GetGuestVersion("guest-1")
+	assert.Equal(t, int64(1), version)
+
+	read, _ = repo.GetPromotionByCode("SQLGUEST")
+	read.Balance--
+	err = repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Guest: "guest-1", GuestVersion: 0})
+	assert.Equal(t, p.ErrPromoVersionConflict, err, "the guest has booked since the version was read")
+	saved, _ = repo.GetPromotionByCode("SQLGUEST")
+	assert.Equal(t, int64(9), saved.Balance)
+
+	assert.Nil(t, repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Guest: "guest-1", GuestVersion: 1}))
+	version, _ = repo.GetGuestVersion("guest-1")
+	assert.Equal(t, int64(2), version)
+}
+
+func TestSQLRepositorySaveBooking(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	promo := newQuotaPromo("SQLBOOKING", 5)
+	assert.Nil(t, repo.Save(promo))
+
+	redemption := func() *p.Redemption {
+		id, _ := uuid.NewV4()
+		return &p.Redemption{ID: id, PromoID: promo.ID, Code: promo.Code, BookingRef: "BOOKING-1", CreatedAt: time.Now()}
+	}
+
+	read, _ := repo.GetPromotionByCode("SQLBOOKING")
+	read.Balance--
+	assert.Nil(t, repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Redemptions: []*p.Redemption{redemption()}}))
+
+	read, _ = repo.GetPromotionByCode("SQLBOOKING")
+	assert.Equal(t, int64(4), read.Balance)
+	read.Balance--
+	err := repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Redemptions: []*p.Redemption{redemption()}})
+	assert.Equal(t, p.ErrBookingAlreadyRedeemed, err)
+
+	saved, _ := repo.GetPromotionByCode("SQLBOOKING")
+	assert.Equal(t, int64(4), saved.Balance, "the quota is not taken without the redemption")
+	redemptions, _ := repo.GetRedemptionsByBookingRef("BOOKING-1")
+	assert.Equal(t, 1, len(redemptions))
+	assert.Equal(t, p.ErrBookingAlreadyRedeemed, repo.SaveRedemption(redemption()))
+
+	holdID, _ := uuid.NewV4()
+	hold := &p.Hold{ID: holdID, PromoID: promo.ID, Code: promo.Code, BookingRef: "BOOKING-2", Status: p.HoldStatusHeld,
+		CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Minute)}
+	read, _ = repo.GetPromotionByCode("SQLBOOKING")
+	read.Balance--
+	assert.Nil(t, repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Holds: []*p.Hold{hold}}))
+
+	settled := *hold
+	settled.Status = p.HoldStatusCancelled
+	read, _ = repo.GetPromotionByCode("SQLBOOKING")
+	read.Balance++
+	assert.Nil(t, repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Settled: []*p.Hold{&settled}}))
+
+	read, _ = repo.GetPromotionByCode("SQLBOOKING")
+	read.Balance++
+	err = repo.SaveBooking(&p.BookingChange{Promos: []*p.Promotion{read}, Settled: []*p.Hold{&settled}})
+	assert.Equal(t, p.ErrHoldNotHeld, err)
+	saved, _ = repo.GetPromotionByCode("SQLBOOKING")
+	assert.Equal(t, int64(4), saved.Balance, "the quota of a hold is given back once")
+}
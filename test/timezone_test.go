@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestParseTimeIn(t *testing.T) {
+	jakarta, err := utils.LoadTimezone("Asia/Jakarta")
+	assert.Nil(t, err)
+
+	local, err := utils.ParseTimeIn("2020-01-02 20:00:00", jakarta)
+	assert.Nil(t, err)
+	assert.Equal(t, "2020-01-02T13:00:00Z", local.UTC().Format(time.RFC3339))
+
+	offset, err := utils.ParseTimeIn("2020-01-02T20:00:00+07:00", time.UTC)
+	assert.Nil(t, err)
+	assert.True(t, offset.Equal(local), "an RFC 3339 offset wins over the location")
+
+	utc, err := utils.ParseTimeFromString("2020-01-02 20:00:00")
+	assert.Nil(t, err)
+	assert.Equal(t, "2020-01-02T20:00:00Z", utc.Format(time.RFC3339))
+
+	_, err = utils.LoadTimezone("Asia/Atlantis")
+	assert.NotNil(t, err)
+}
+
+func TestFunctionPromoTimezone(t *testing.T) {
+	window := &p.BookingWindow{Start: "20:00", End: "22:00"}
+	req := p.PromoRequest{
+		Code:           "JAKARTAFLASH",
+		Percentage:     null.IntFrom(10),
+		Quota:          5,
+		Timezone:       "Asia/Jakarta",
+		StartDate:      null.StringFrom("2020-01-01 00:00:00"),
+		EndDate:        null.StringFrom("2099-12-31 00:00:00"),
+		BookingWindows: p.WindowList{window},
+	}
+	assert.Nil(t, req.Validate())
+	id, _ := uuid.NewV4()
+	flash, err := req.ToPromo(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "2019-12-31T17:00:00Z", flash.StartDate.Time.UTC().Format(time.RFC3339))
+	assert.Equal(t, "2020-01-01 00:00:00", p.NewPromoRequest(flash).StartDate.String)
+
+	req.Code = "NEWYORKFLASH"
+	req.Timezone = "America/New_York"
+	id, _ = uuid.NewV4()
+	newYork, err := req.ToPromo(id)
+	assert.Nil(t, err)
+
+	// 20:30 in Jakarta is 08:30 in New York
+	bookingTime, _ := utils.ParseTimeFromString("2020-01-02T13:30:00Z")
+	service := p.NewService(p.NewRepository([]*p.Promotion{flash, newYork})).WithClock(p.FixedClock(bookingTime))
+	room := &p.RoomRequest{Date: "2020-01-02T14:00:00+07:00", Room: "Deluxe", Price: p.NewDecimal(100000)}
+	res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "JAKARTAFLASH"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(10000), res.PromoPrice)
+
+	res, err = service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "NEWYORKFLASH"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Promo not applied: booking window rule is failed", res.Rooms[0].Message)
+
+	req.Timezone = "Mars/Olympus"
+	assert.NotNil(t, req.Validate())
+}
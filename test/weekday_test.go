@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestParseWeekdays(t *testing.T) {
+	days, err := utils.ParseWeekdays("Mon, wednesday")
+	assert.Nil(t, err)
+	assert.Equal(t, utils.NewWeekdaySet(time.Monday, time.Wednesday), days)
+
+	days, err = utils.ParseWeekdays("Friday-Sunday")
+	assert.Nil(t, err)
+	assert.Equal(t, utils.NewWeekdaySet(time.Friday, time.Saturday, time.Sunday), days, "a range may wrap around the week")
+
+	days, err = utils.ParseWeekdays("WEEKEND,tue")
+	assert.Nil(t, err)
+	assert.Equal(t, utils.NewWeekdaySet(time.Saturday, time.Sunday, time.Tuesday), days)
+
+	days, err = utils.ParseWeekdays("weekday")
+	assert.Nil(t, err)
+	assert.False(t, days.Contains(time.Sunday))
+	assert.True(t, days.Contains(time.Friday))
+
+	for _, invalid := range []string{"", "Funday", "Mon-", "Mon-Wed-Fri", "Mon,,Tue"} {
+		_, err = utils.ParseWeekdays(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestFunctionPromoWeekdays(t *testing.T) {
+	weekend := newQuotaPromo("WEEKEND", 5)
+	weekend.Rules = p.RuleList{&p.CheckinDayRule{Days: "fri-sun"}}
+	weekendService := p.NewService(p.NewRepository([]*p.Promotion{weekend}))
+
+	saturday := &p.RoomRequest{Date: "2020-02-15 10:00:00", Room: "Saturday", Price: p.NewDecimal(100000)}
+	monday := &p.RoomRequest{Date: "2020-02-17 10:00:00", Room: "Monday", Price: p.NewDecimal(100000)}
+	res, err := weekendService.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{saturday, monday}, Code: "WEEKEND"})
+	assert.Nil(t, err)
+	assert.Equal(t, p.NewDecimal(10000), res.Rooms[0].Saving)
+	assert.Contains(t, res.Rooms[1].Message, "checkin time rule is failed")
+
+	req := p.PromoRequest{Code: "INVALIDDAY", Percentage: null.IntFrom(10), BookingDays: null.StringFrom("Someday")}
+	assert.NotNil(t, req.Validate(), "invalid weekdays are rejected when the promo is saved")
+	req.BookingDays = null.StringFrom("weekday")
+	assert.Nil(t, req.Validate())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	p "github.com/chandrafortuna/simple-promotion-api/domain/promotion"
+	"github.com/chandrafortuna/simple-promotion-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	minutes, err := utils.ParseTimeOfDay("23:30")
+	assert.Nil(t, err)
+	assert.Equal(t, 23*60+30, minutes)
+
+	for _, v := range []string{"24:00", "7pm", "12:60", ""} {
+		_, err = utils.ParseTimeOfDay(v)
+		assert.NotNil(t, err, v)
+	}
+}
+
+func TestFunctionBookingWindows(t *testing.T) {
+	flashSale := p.WindowList{{Days: "Friday", Start: "23:30", End: "00:30"}}
+	cases := []struct {
+		name        string
+		windows     p.WindowList
+		bookingTime string
+		applied     bool
+	}{
+		{"friday night", flashSale, "2020-02-14T23:45:00+07:00", true},
+		{"after midnight", flashSale, "2020-02-15T00:29:00+07:00", true},
+		{"window end is exclusive", flashSale, "2020-02-15T00:30:00+07:00", false},
+		{"before the window", flashSale, "2020-02-14T23:29:00+07:00", false},
+		{"thursday window", flashSale, "2020-02-14T00:15:00+07:00", false},
+		{"saturday night", flashSale, "2020-02-15T23:45:00+07:00", false},
+		{"in jakarta", flashSale, "2020-02-14T16:45:00Z", true},
+		{"second window", p.WindowList{
+			{Start: "08:00", End: "09:00"},
+			{Start: "12:00", End: "13:15"},
+		}, "2020-02-14T13:14:00+07:00", true},
+		{"between windows", p.WindowList{
+			{Start: "08:00", End: "09:00"},
+			{Start: "12:00", End: "13:15"},
+		}, "2020-02-14T10:00:00+07:00", false},
+		{"whole day", p.WindowList{{Days: "weekend", Start: "00:00", End: "00:00"}}, "2020-02-15T03:00:00+07:00", true},
+		{"not the day", p.WindowList{{Days: "weekend", Start: "00:00", End: "00:00"}}, "2020-02-14T03:00:00+07:00", false},
+	}
+
+	for _, c := range cases {
+		req := p.PromoRequest{Code: "WINDOW", Percentage: null.IntFrom(10), Quota: 5, Timezone: "Asia/Jakarta", BookingWindows: c.windows}
+		assert.Nil(t, req.Validate(), c.name)
+		promo, err := req.ToPromo(newQuotaPromo("WINDOW", 5).ID)
+		assert.Nil(t, err)
+
+		bookingTime, _ := utils.ParseTimeFromString(c.bookingTime)
+		service := p.NewService(p.NewRepository([]*p.Promotion{promo})).WithClock(p.FixedClock(bookingTime))
+		room := &p.RoomRequest{Date: "2020-02-14 14:00:00", Room: "Deluxe", Price: p.NewDecimal(100000)}
+		res, err := service.ApplyPromotion(p.ApplyPromoRequest{Rooms: []*p.RoomRequest{room}, Code: "WINDOW"})
+		assert.Nil(t, err)
+		if c.applied {
+			assert.Equal(t, p.NewDecimal(10000), res.PromoPrice, c.name)
+		} else {
+			assert.Equal(t, "Promo not applied: booking window rule is failed", res.Rooms[0].Message, c.name)
+		}
+	}
+
+	req := p.PromoRequest{Code: "WINDOW", Percentage: null.IntFrom(10), BookingWindows: p.WindowList{{Start: "23:30", End: "25:00"}}}
+	assert.NotNil(t, req.Validate())
+	req.BookingWindows = p.WindowList{{Days: "Funday", Start: "23:30", End: "00:30"}}
+	assert.NotNil(t, req.Validate())
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"gopkg.in/guregu/null.v3"
@@ -50,12 +52,55 @@ func NullTimeToTime(value null.Time) time.Time {
 	return time.Time{}
 }
 
-// ParseTimeFromString parse string into Time
+// TimeLayout is the layout of the time strings accepted and produced by the API
+const TimeLayout = "2006-01-02 15:04:05"
+
+// ParseTimeFromString parse string into Time, a TimeLayout string is read as UTC
 func ParseTimeFromString(value string) (time.Time, error) {
-	res, err := time.Parse("2006-01-02 15:04:05", value)
+	return ParseTimeIn(value, time.UTC)
+}
+
+// ParseTimeIn parse an RFC 3339 timestamp such as "2020-01-02T20:00:00+07:00", or a TimeLayout string which
+// is read as the wall clock of loc
+func ParseTimeIn(value string, loc *time.Location) (time.Time, error) {
+	if res, err := time.Parse(time.RFC3339, value); err == nil {
+		return res, nil
+	}
+
+	res, err := time.ParseInLocation(TimeLayout, value, loc)
 	if err != nil {
 		return time.Time{}, errors.New("Parse Time Failed")
 	}
 
 	return res, nil
 }
+
+var timezones sync.Map
+
+// LoadTimezone returns the location of an IANA timezone name such as "Asia/Jakarta", empty string is UTC
+func LoadTimezone(name string) (*time.Location, error) {
+	if loc, ok := timezones.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone '%s'", name)
+	}
+
+	timezones.Store(name, loc)
+	return loc, nil
+}
+
+// DateLayout is the layout of the date strings accepted and produced by the API
+const DateLayout = "2006-01-02"
+
+// ParseDate parse string into the Time of the start of the date
+func ParseDate(value string) (time.Time, error) {
+	res, err := time.Parse(DateLayout, value)
+	if err != nil {
+		return time.Time{}, errors.New("Parse Date Failed")
+	}
+
+	return res, nil
+}
@@ -2,23 +2,108 @@ package utils
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
 var daysOfWeek = map[string]time.Weekday{
-	"Sunday":    time.Sunday,
-	"Monday":    time.Monday,
-	"Tuesday":   time.Tuesday,
-	"Wednesday": time.Wednesday,
-	"Thursday":  time.Thursday,
-	"Friday":    time.Friday,
-	"Saturday":  time.Saturday,
+	"sunday":    time.Sunday,
+	"sun":       time.Sunday,
+	"monday":    time.Monday,
+	"mon":       time.Monday,
+	"tuesday":   time.Tuesday,
+	"tue":       time.Tuesday,
+	"tues":      time.Tuesday,
+	"wednesday": time.Wednesday,
+	"wed":       time.Wednesday,
+	"thursday":  time.Thursday,
+	"thu":       time.Thursday,
+	"thur":      time.Thursday,
+	"thurs":     time.Thursday,
+	"friday":    time.Friday,
+	"fri":       time.Friday,
+	"saturday":  time.Saturday,
+	"sat":       time.Saturday,
 }
 
+// WeekdaySet represent a set of weekdays
+type WeekdaySet uint8
+
+var weekdayAliases = map[string]WeekdaySet{
+	"weekend":  NewWeekdaySet(time.Saturday, time.Sunday),
+	"weekends": NewWeekdaySet(time.Saturday, time.Sunday),
+	"weekday":  NewWeekdaySet(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday),
+	"weekdays": NewWeekdaySet(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday),
+}
+
+// NewWeekdaySet returns the set of the given weekdays
+func NewWeekdaySet(days ...time.Weekday) WeekdaySet {
+	var s WeekdaySet
+	for _, d := range days {
+		s |= 1 << uint(d)
+	}
+	return s
+}
+
+// Contains reports whether the weekday is in the set
+func (s WeekdaySet) Contains(d time.Weekday) bool {
+	return s&(1<<uint(d)) != 0
+}
+
+// ParseWeekday parse a full or short weekday name such as "Monday" or "mon", case insensitive
 func ParseWeekday(v string) (time.Weekday, error) {
-	if d, ok := daysOfWeek[v]; ok {
+	if d, ok := daysOfWeek[strings.ToLower(strings.TrimSpace(v))]; ok {
 		return d, nil
 	}
 
 	return time.Sunday, fmt.Errorf("invalid weekday '%s'", v)
 }
+
+// ParseWeekdays parse a comma separated list of weekdays, ranges such as "Friday-Sunday" which may wrap
+// around the week, and the aliases weekend and weekday
+func ParseWeekdays(v string) (WeekdaySet, error) {
+	var set WeekdaySet
+	for _, item := range strings.Split(v, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if alias, ok := weekdayAliases[item]; ok {
+			set |= alias
+			continue
+		}
+
+		bounds := strings.Split(item, "-")
+		if len(bounds) > 2 {
+			return 0, fmt.Errorf("invalid weekday range '%s'", item)
+		}
+
+		from, err := ParseWeekday(bounds[0])
+		if err != nil {
+			return 0, err
+		}
+
+		to := from
+		if len(bounds) == 2 {
+			if to, err = ParseWeekday(bounds[1]); err != nil {
+				return 0, err
+			}
+		}
+
+		for d := from; ; d = (d + 1) % 7 {
+			set |= NewWeekdaySet(d)
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// ParseTimeOfDay parse a HH:MM time of day such as "23:30" into the minutes since midnight
+func ParseTimeOfDay(v string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s'", v)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}